@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"openrouter-gpt-telegram-bot/api"
+	"openrouter-gpt-telegram-bot/lang"
+	"openrouter-gpt-telegram-bot/logging"
+	"openrouter-gpt-telegram-bot/transport"
+)
+
+// handleAnswerCallback handles the buttons under answers: stop stops the answer that is
+// streaming, clear clears the conversation, regenerate answers the latest question again
+// and continue asks the model to go on with the latest answer.
+func (d *Dispatcher) handleAnswerCallback(ctx context.Context, update transport.Update, action string) {
+	conf := d.conf()
+	query := update.CallbackQuery
+	sender, conversation, payer := d.trackers(query.From, query.Message.Chat, update.MessageThreadID)
+
+	switch action {
+	case "stop":
+		if conversation.StopGeneration(api.ErrStopped) {
+			d.answerCallback(ctx, query, lang.Translate("commands.stop", conf.Lang))
+		} else {
+			d.answerCallback(ctx, query, lang.Translate("commands.stop_err", conf.Lang))
+		}
+	case "clear":
+		conversation.ClearHistory()
+		d.answerCallback(ctx, query, lang.Translate("commands.reset", conf.Lang))
+		d.removeKeyboard(ctx, query.Message)
+	case "regenerate", "continue":
+		if !conversation.IsLatestAnswer(query.Message.MessageID) {
+			d.answerCallback(ctx, query, lang.Translate("actions.outdated", conf.Lang))
+			return
+		}
+		d.answerCallback(ctx, query, "")
+		message := actionMessage(query)
+		if !d.allowRequest(ctx, message, sender) {
+			return
+		}
+		// The new answer gets its own buttons
+		d.removeKeyboard(ctx, query.Message)
+		answered := query.Message.MessageID
+		d.submitTurn(ctx, message, conversation, func(ctx context.Context) {
+			// A queued turn may have answered in the meantime
+			if !conversation.IsLatestAnswer(answered) {
+				d.bot.Send(newReply(message, lang.Translate("actions.outdated", conf.Lang)))
+				return
+			}
+			if action == "continue" {
+				// The prompt is not a message of the user, so edits never match it
+				message.Text = lang.Translate("actions.continue_prompt", conf.Lang)
+				d.answerInto(ctx, message, sender, conversation, payer, 0, nil)
+				return
+			}
+
+			// The turn is put back if no new answer replaces it
+			snapshot := conversation.Snapshot()
+			question, ok := conversation.TakeLastTurn()
+			if !ok {
+				d.bot.Send(newReply(message, lang.Translate("actions.outdated", conf.Lang)))
+				return
+			}
+			message.Text = question.Content
+			if len(question.MessageIDs) > 0 {
+				// Keeps the question editable and the answer replying to it
+				message.MessageID = question.MessageIDs[0]
+			}
+			if !d.answer(ctx, message, sender, conversation, payer) {
+				conversation.Restore(snapshot)
+				// The old answer can be regenerated again
+				d.restoreKeyboard(ctx, query.Message)
+			}
+		})
+	default:
+		logging.From(ctx).Warn("Unknown answer action", "action", action)
+		d.answerCallback(ctx, query, "")
+	}
+}
+
+// actionMessage returns the message an answer button acts for. In groups answers reply
+// to the question, so the new answer replies to it too.
+func actionMessage(query *tgbotapi.CallbackQuery) *tgbotapi.Message {
+	messageID := query.Message.MessageID
+	if query.Message.ReplyToMessage != nil {
+		messageID = query.Message.ReplyToMessage.MessageID
+	}
+	return &tgbotapi.Message{
+		MessageID: messageID,
+		From:      query.From,
+		Chat:      query.Message.Chat,
+	}
+}
+
+// restoreKeyboard shows the answer buttons under a message again.
+func (d *Dispatcher) restoreKeyboard(ctx context.Context, message *tgbotapi.Message) {
+	edit := tgbotapi.NewEditMessageReplyMarkup(message.Chat.ID, message.MessageID, *api.AnswerKeyboard(d.conf().Lang))
+	if _, err := d.bot.Send(edit); err != nil {
+		logging.From(ctx).Error("Failed to restore answer buttons", "error", err)
+	}
+}
+
+// removeKeyboard removes the inline keyboard of a message.
+func (d *Dispatcher) removeKeyboard(ctx context.Context, message *tgbotapi.Message) {
+	edit := tgbotapi.NewEditMessageReplyMarkup(message.Chat.ID, message.MessageID,
+		tgbotapi.InlineKeyboardMarkup{InlineKeyboard: [][]tgbotapi.InlineKeyboardButton{}})
+	if _, err := d.bot.Send(edit); err != nil {
+		logging.From(ctx).Error("Failed to remove answer buttons", "error", err)
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"html"
+	"openrouter-gpt-telegram-bot/lang"
+	"openrouter-gpt-telegram-bot/logging"
+	"openrouter-gpt-telegram-bot/user"
+	"strconv"
+	"strings"
+)
+
+// adminCommands are only available to users with the ADMIN role.
+var adminCommands = map[string]bool{
+	"grant":      true,
+	"revoke":     true,
+	"removeuser": true,
+	"setbudget":  true,
+	"resetusage": true,
+	"users":      true,
+	"whois":      true,
+}
+
+// handleAdminCommand runs an admin command. Commands that take a user accept its ID
+// as the first argument or act on the sender of the replied-to message.
+func (d *Dispatcher) handleAdminCommand(ctx context.Context, message *tgbotapi.Message, sender *user.UsageTracker) {
+	conf := d.conf()
+	if sender.GetUserRole(conf) != "ADMIN" {
+		d.sendHTML(ctx, message, lang.Translate("admin.denied", conf.Lang))
+		return
+	}
+
+	command := message.Command()
+	if command == "users" {
+		d.sendHTML(ctx, message, d.usersList())
+		return
+	}
+
+	targetID, name, args, ok := commandTarget(message)
+	if !ok {
+		d.sendHTML(ctx, message, lang.Translate("admin.usage."+command, conf.Lang))
+		return
+	}
+	if known, ok := d.userManager.Lookup(targetID); ok && name == "" {
+		name = known.UserName
+	}
+	id := strconv.FormatInt(targetID, 10)
+	roster := d.userManager.Roster
+
+	var err error
+	var reply string
+	switch command {
+	case "grant":
+		role := "USER"
+		if len(args) > 0 {
+			role = strings.ToUpper(args[0])
+		}
+		if role != "USER" && role != "ADMIN" {
+			d.sendHTML(ctx, message, lang.Translate("admin.usage.grant", conf.Lang))
+			return
+		}
+		err = roster.SetRole(id, name, role)
+		reply = fmt.Sprintf(lang.Translate("admin.granted", conf.Lang), id, role)
+	case "revoke":
+		err = roster.SetRole(id, name, "GUEST")
+		reply = fmt.Sprintf(lang.Translate("admin.revoked", conf.Lang), id)
+	case "removeuser":
+		var removed bool
+		removed, err = roster.Remove(id)
+		reply = fmt.Sprintf(lang.Translate("admin.removed", conf.Lang), id)
+		if !removed {
+			reply = fmt.Sprintf(lang.Translate("admin.not_listed", conf.Lang), id)
+		}
+	case "setbudget":
+		if len(args) == 0 {
+			d.sendHTML(ctx, message, lang.Translate("admin.usage.setbudget", conf.Lang))
+			return
+		}
+		budget, parseErr := strconv.ParseFloat(args[0], 64)
+		if parseErr != nil || budget < 0 {
+			d.sendHTML(ctx, message, lang.Translate("admin.usage.setbudget", conf.Lang))
+			return
+		}
+		err = roster.SetBudget(id, name, budget)
+		reply = fmt.Sprintf(lang.Translate("admin.budget_set", conf.Lang), id, budget, conf.BudgetPeriod)
+	case "resetusage":
+		err = d.userManager.GetUser(targetID, name, conf).ResetUsage()
+		reply = fmt.Sprintf(lang.Translate("admin.usage_reset", conf.Lang), id)
+	case "whois":
+		reply = d.whois(d.userManager.GetUser(targetID, name, conf))
+	}
+
+	if err != nil {
+		logging.From(ctx).Error("Admin command failed", "command", command, "target_id", id, "error", err)
+		reply = lang.Translate("admin.failed", conf.Lang)
+	}
+	d.sendHTML(ctx, message, reply)
+}
+
+// commandTarget returns the user an admin command is about, its name if the command
+// replies to one of its messages, and the remaining arguments. In a reply a single
+// argument is not a user ID, so /setbudget 10 sets the budget of the replied-to user.
+func commandTarget(message *tgbotapi.Message) (int64, string, []string, bool) {
+	args := strings.Fields(message.CommandArguments())
+	reply := message.ReplyToMessage
+	isReply := reply != nil && reply.From != nil
+	if len(args) > 0 && (!isReply || len(args) > 1) {
+		if id, err := strconv.ParseInt(args[0], 10, 64); err == nil {
+			return id, "", args[1:], true
+		}
+	}
+	if isReply {
+		return reply.From.ID, reply.From.UserName, args, true
+	}
+	return 0, "", nil, false
+}
+
+// usersList describes the roster followed by the users from the static config lists.
+func (d *Dispatcher) usersList() string {
+	conf := d.conf()
+	roster := d.userManager.Roster
+
+	var b strings.Builder
+	b.WriteString(lang.Translate("admin.users", conf.Lang))
+	for _, id := range roster.IDs() {
+		entry, _ := roster.Get(id)
+		line := fmt.Sprintf("\n<code>%s</code> %s", id, html.EscapeString(entry.Name))
+		if entry.Role != "" {
+			line += " " + entry.Role
+		}
+		if entry.Budget != nil {
+			line += fmt.Sprintf(" $%.2f", *entry.Budget)
+		}
+		b.WriteString(line)
+	}
+
+	b.WriteString("\n\n" + lang.Translate("admin.users_config", conf.Lang))
+	for _, id := range conf.AdminChatIDs {
+		b.WriteString(fmt.Sprintf("\n<code>%d</code> ADMIN", id))
+	}
+	for _, id := range conf.AllowedUserChatIDs {
+		b.WriteString(fmt.Sprintf("\n<code>%d</code> USER", id))
+	}
+	return b.String()
+}
+
+func (d *Dispatcher) whois(target *user.UsageTracker) string {
+	conf := d.conf()
+	return fmt.Sprintf(lang.Translate("admin.whois", conf.Lang),
+		target.UserID,
+		html.EscapeString(target.UserName),
+		target.GetUserRole(conf),
+		target.GetBudget(conf),
+		conf.BudgetPeriod,
+		target.GetCurrentCost(conf.BudgetPeriod),
+		target.GetCurrentCost("total"),
+		target.ModelFor(conf, target.GetUserRole(conf)))
+}
+
+func (d *Dispatcher) sendHTML(ctx context.Context, message *tgbotapi.Message, text string) {
+	msg := newReply(message, text)
+	msg.ParseMode = "HTML"
+	if _, err := d.bot.Send(msg); err != nil {
+		logging.From(ctx).Error("Failed to send message", "error", err)
+	}
+}
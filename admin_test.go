@@ -0,0 +1,51 @@
+package main
+
+import (
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func commandMessage(text string, replyTo *tgbotapi.User) *tgbotapi.Message {
+	command := strings.Fields(text)[0]
+	message := &tgbotapi.Message{
+		Text:     text,
+		Entities: []tgbotapi.MessageEntity{{Type: "bot_command", Offset: 0, Length: len(command)}},
+	}
+	if replyTo != nil {
+		message.ReplyToMessage = &tgbotapi.Message{From: replyTo}
+	}
+	return message
+}
+
+func TestCommandTarget(t *testing.T) {
+	author := &tgbotapi.User{ID: 42, UserName: "alice"}
+	tests := []struct {
+		name    string
+		text    string
+		replyTo *tgbotapi.User
+		id      int64
+		user    string
+		args    []string
+		ok      bool
+	}{
+		{"id", "/setbudget 7 10", nil, 7, "", []string{"10"}, true},
+		{"id only", "/whois 7", nil, 7, "", []string{}, true},
+		{"budget by reply", "/setbudget 10", author, 42, "alice", []string{"10"}, true},
+		{"id and budget in a reply", "/setbudget 7 10", author, 7, "", []string{"10"}, true},
+		{"role by reply", "/grant admin", author, 42, "alice", []string{"admin"}, true},
+		{"reply without arguments", "/whois", author, 42, "alice", []string{}, true},
+		{"no target", "/whois", nil, 0, "", nil, false},
+		{"no id", "/grant admin", nil, 0, "", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, user, args, ok := commandTarget(commandMessage(tt.text, tt.replyTo))
+			if id != tt.id || user != tt.user || ok != tt.ok || len(args)+len(tt.args) > 0 && !reflect.DeepEqual(args, tt.args) {
+				t.Errorf("commandTarget(%q) = %d, %q, %q, %v, want %d, %q, %q, %v",
+					tt.text, id, user, args, ok, tt.id, tt.user, tt.args, tt.ok)
+			}
+		})
+	}
+}
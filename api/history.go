@@ -0,0 +1,106 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"github.com/sashabaranov/go-openai"
+	"openrouter-gpt-telegram-bot/config"
+	"openrouter-gpt-telegram-bot/logging"
+	"openrouter-gpt-telegram-bot/provider"
+	"openrouter-gpt-telegram-bot/tokenizer"
+	"openrouter-gpt-telegram-bot/user"
+	"strings"
+	"time"
+)
+
+// summaryMaxTokens caps the length of the summary that replaces dropped turns.
+const summaryMaxTokens = 500
+
+// chatMessages converts stored history to chat completion messages.
+func chatMessages(history []user.Message) []openai.ChatCompletionMessage {
+	messages := make([]openai.ChatCompletionMessage, 0, len(history))
+	for _, msg := range history {
+		messages = append(messages, openai.ChatCompletionMessage{
+			Role:    msg.Role,
+			Content: msg.Content,
+		})
+	}
+	return messages
+}
+
+// FitHistory makes the system prompt, the history and the new message fit the context
+// budget of model while leaving MaxTokens for the answer. The oldest turns that do
+// not fit are dropped, or replaced by a summary when HistoryOverflow is summarize.
+// It returns the ID of the summary generation, empty if none was made.
+func FitHistory(ctx context.Context, p provider.Provider, config *config.Config, tracker *user.UsageTracker, model, text string) string {
+	budget := config.ContextBudgetFor(model) - config.MaxTokens
+	fixed := tokenizer.CountMessages(model, []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleSystem, Content: tracker.PromptFor(config)},
+		{Role: openai.ChatMessageRoleUser, Content: text},
+	})
+
+	fits := func(reserved int) func([]user.Message) bool {
+		return func(history []user.Message) bool {
+			// CountMessages includes the reply priming, which fixed already has
+			return fixed+reserved+tokenizer.CountMessages(model, chatMessages(history))-3 <= budget
+		}
+	}
+
+	dropped := tracker.TrimHistory(fits(0))
+	if len(dropped) == 0 {
+		return ""
+	}
+	if config.HistoryOverflow == "summarize" {
+		// The summary is added to what is left, so room is made for its longest length
+		summaryTokens := tokenizer.CountMessages(model, []openai.ChatCompletionMessage{
+			{Role: openai.ChatMessageRoleSystem, Content: user.SummaryPrefix},
+		}) - 3 + summaryMaxTokens
+		dropped = append(dropped, tracker.TrimHistory(fits(summaryTokens))...)
+	}
+	logging.From(ctx).Info("Dropped messages exceeding the context budget", "model", model, "dropped", len(dropped), "budget", budget)
+	if config.HistoryOverflow != "summarize" {
+		return ""
+	}
+
+	summary, responseID, err := summarize(ctx, p, model, dropped)
+	if err != nil {
+		logging.From(ctx).Error("Failed to summarize dropped messages", "model", model, "error", err)
+		return responseID
+	}
+	tracker.AddSummary(summary)
+	return responseID
+}
+
+// summarize asks the model for a short summary of the given turns.
+func summarize(ctx context.Context, p provider.Provider, model string, history []user.Message) (string, string, error) {
+	var transcript strings.Builder
+	for _, msg := range history {
+		transcript.WriteString(fmt.Sprintf("%s: %s\n\n", msg.Role, msg.Content))
+	}
+
+	req := openai.ChatCompletionRequest{
+		Model:     model,
+		MaxTokens: summaryMaxTokens,
+		Messages: []openai.ChatCompletionMessage{
+			{
+				Role:    openai.ChatMessageRoleSystem,
+				Content: "Summarize the following conversation in a few sentences. Keep facts, names, decisions and open questions that later messages may refer to.",
+			},
+			{
+				Role:    openai.ChatMessageRoleUser,
+				Content: transcript.String(),
+			},
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
+	defer cancel()
+	resp, err := p.Chat(ctx, req)
+	if err != nil {
+		return "", "", err
+	}
+	if len(resp.Choices) == 0 {
+		return "", resp.ID, fmt.Errorf("empty summary response")
+	}
+	return resp.Choices[0].Message.Content, resp.ID, nil
+}
@@ -0,0 +1,38 @@
+package api
+
+import (
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"openrouter-gpt-telegram-bot/lang"
+)
+
+// Callback data of the answer buttons, the dispatcher routes them by the answer prefix.
+const (
+	ActionRegenerate = "answer:regenerate"
+	ActionContinue   = "answer:continue"
+	ActionStop       = "answer:stop"
+	ActionClear      = "answer:clear"
+)
+
+// streamingKeyboard is shown under an answer while it streams in.
+func streamingKeyboard(language string) *tgbotapi.InlineKeyboardMarkup {
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(lang.Translate("actions.stop", language), ActionStop),
+		),
+	)
+	return &keyboard
+}
+
+// AnswerKeyboard is shown under a finished answer.
+func AnswerKeyboard(language string) *tgbotapi.InlineKeyboardMarkup {
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(lang.Translate("actions.regenerate", language), ActionRegenerate),
+			tgbotapi.NewInlineKeyboardButtonData(lang.Translate("actions.continue", language), ActionContinue),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(lang.Translate("actions.clear", language), ActionClear),
+		),
+	)
+	return &keyboard
+}
@@ -7,31 +7,55 @@ import (
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"github.com/sashabaranov/go-openai"
 	"io"
-	"log"
 	"openrouter-gpt-telegram-bot/config"
+	"openrouter-gpt-telegram-bot/lang"
+	"openrouter-gpt-telegram-bot/logging"
+	"openrouter-gpt-telegram-bot/metrics"
+	"openrouter-gpt-telegram-bot/provider"
 	"openrouter-gpt-telegram-bot/user"
 	"time"
 )
 
-func HandleChatGPTStreamResponse(bot *tgbotapi.BotAPI, client *openai.Client, message *tgbotapi.Message, config *config.Config, user *user.UsageTracker) string {
-	ctx := context.Background()
+var (
+	// ErrStopped is the cause of the cancellation of answers stopped with /stop.
+	ErrStopped = errors.New("stopped by the user")
+	// ErrShutdown is the cause of the cancellation of answers that are stopped because the bot shuts down.
+	ErrShutdown = errors.New("bot is shutting down")
+)
+
+// stopNotice returns the notice shown under an answer whose context was canceled with
+// cause, or false if the answer was replaced by a newer message.
+func stopNotice(cause error, language string) (string, bool) {
+	switch {
+	case errors.Is(cause, ErrStopped):
+		return lang.Translate("answer.stopped", language), true
+	case errors.Is(cause, ErrShutdown):
+		return lang.Translate("answer.shutdown", language), true
+	default:
+		return "", false
+	}
+}
+
+// HandleChatGPTStreamResponse streams the answer of model to message and returns its
+// generation ID and whether the turn was added to the history. Fallback models are
+// limited to those role may use. The question is saved with questionID, 0 for prompts
+// the user did not write. The answer is shown in the messages of an earlier answer given in answerIDs before new
+// messages are sent, answerIDs is nil for a new question.
+func HandleChatGPTStreamResponse(ctx context.Context, bot *tgbotapi.BotAPI, p provider.Provider, message *tgbotapi.Message, config *config.Config, user *user.UsageTracker, role, model string, questionID int, answerIDs []int) (string, bool) {
+	ctx, done := user.StartGeneration(ctx)
+	defer done()
 	user.CheckHistory(config.MaxHistorySize, config.MaxHistoryTime)
-	user.LastMessageTime = time.Now()
+	user.Touch(time.Now())
 	messages := []openai.ChatCompletionMessage{
 		{
 			Role:    openai.ChatMessageRoleSystem,
-			Content: user.SystemPrompt,
+			Content: user.PromptFor(config),
 		},
 	}
 
-	for _, msg := range user.GetMessages() {
-		messages = append(messages, openai.ChatCompletionMessage{
-			Role:    msg.Role,
-			Content: msg.Content,
-		})
-	}
+	messages = append(messages, chatMessages(user.GetMessages())...)
 	if config.Vision == "true" {
-		messages = append(messages, addVisionMessage(bot, message, config))
+		messages = append(messages, addVisionMessage(ctx, bot, message, config))
 	} else {
 		messages = append(messages, openai.ChatCompletionMessage{
 			Role:    openai.ChatMessageRoleUser,
@@ -39,89 +63,120 @@ func HandleChatGPTStreamResponse(bot *tgbotapi.BotAPI, client *openai.Client, me
 		})
 	}
 	req := openai.ChatCompletionRequest{
-		Model:            config.Model.ModelName,
+		Model:            model,
 		FrequencyPenalty: float32(config.Model.FrequencyPenalty),
 		PresencePenalty:  float32(config.Model.PresencePenalty),
-		Temperature:      float32(config.Model.Temperature),
+		Temperature:      float32(user.TemperatureFor(config)),
 		TopP:             float32(config.Model.TopP),
 		MaxTokens:        config.MaxTokens,
 		Messages:         messages,
 		Stream:           true,
 	}
 
-	stream, err := client.CreateChatCompletionStream(ctx, req)
+	started := time.Now()
+	stream, model, err := openStream(ctx, p, config, role, req)
+	if err != nil && ctx.Err() != nil {
+		logging.From(ctx).Info("Answer canceled before it started")
+		if notice, ok := stopNotice(context.Cause(ctx), config.Lang); ok {
+			replyText(ctx, bot, message, notice)
+		}
+		return "", false
+	}
 	if err != nil {
-		fmt.Printf("ChatCompletionStream error: %v\n", err)
-		//Dont need to show this error to user
-		//msg := tgbotapi.NewMessage(message.Chat.ID, "Error: "+err.Error())
-		//bot.Send(msg)
-		return ""
+		logging.From(ctx).Error("Failed to start answer stream", "model", req.Model, "error", err)
+		replyText(ctx, bot, message, lang.Translate("answer.unavailable", config.Lang))
+		return "", false
 	}
 	defer stream.Close()
-	user.CurrentStream = stream
-	var lastMessageID int
+	metrics.ActiveStreams.Inc()
+	defer metrics.ActiveStreams.Dec()
+	defer func() { metrics.StreamDuration.WithLabelValues(model).Observe(time.Since(started).Seconds()) }()
+	logger := logging.From(ctx).With("model", model)
+	writer := newStreamWriter(ctx, bot, message)
+	writer.Keyboard = streamingKeyboard(config.Lang)
+	writer.reuse = answerIDs
 	var messageText string
-	var lastSentTime time.Time
 	responseID := ""
-	log.Printf("User: " + user.UserName + " Stream response. ")
+	answeredBy := model
+	logger.Debug("Streaming answer")
 	for {
 		response, err := stream.Recv()
 		if responseID == "" {
 			responseID = response.ID
 		}
+		if response.Model != "" {
+			answeredBy = response.Model
+		}
 		if errors.Is(err, io.EOF) {
-			fmt.Println("\nStream finished, response ID:", responseID)
-			user.AddMessage(openai.ChatMessageRoleUser, message.Text)
-			user.AddMessage(openai.ChatMessageRoleAssistant, messageText)
-			editMsg := tgbotapi.NewEditMessageText(message.Chat.ID, lastMessageID, messageText)
-			_, err := bot.Send(editMsg)
-			if err != nil {
-				log.Printf("Failed to edit message: %v", err)
+			logger.Info("Answer finished", "response_id", responseID, "answered_by", answeredBy,
+				"duration", time.Since(started))
+			shown := messageText
+			if !sameModel(req.Model, answeredBy) {
+				shown += "\n\n_" + fmt.Sprintf(lang.Translate("answer.answered_by", config.Lang), answeredBy) + "_"
 			}
-			user.CurrentStream = nil
-			return responseID
+			writer.Keyboard = AnswerKeyboard(config.Lang)
+			if err := writer.Update(shown, true); err != nil {
+				logger.Error("Failed to show answer", "error", err)
+			}
+			user.AddTurn(message.Text, questionID, messageText, writer.MessageIDs)
+			if config.LongAnswerDocument > 0 && utf16Len(messageText) > config.LongAnswerDocument {
+				writer.SendDocument(messageText)
+			}
+			return responseID, true
 		}
 
-		if err != nil {
-			fmt.Printf("\nStream error: %v\n", err)
-			msg := tgbotapi.NewMessage(message.Chat.ID, err.Error())
-			bot.Send(msg)
-			user.CurrentStream = nil
-			return responseID
-		}
-		if lastMessageID == 0 {
-			messageText += response.Choices[0].Delta.Content
-			msg := tgbotapi.NewMessage(message.Chat.ID, messageText)
-			sentMsg, err := bot.Send(msg)
-			if err != nil {
-				//log.Printf("Failed to send message: %v", err)
-				continue
-			}
-			lastMessageID = sentMsg.MessageID
-			lastSentTime = time.Now()
-		} else {
-			if len(response.Choices) > 0 {
-				messageText += response.Choices[0].Delta.Content
-				if time.Since(lastSentTime) >= 800*time.Millisecond {
-					editMsg := tgbotapi.NewEditMessageText(message.Chat.ID, lastMessageID, messageText)
-					_, err := bot.Send(editMsg)
-					if err != nil {
-						log.Printf("Failed to edit message: %v", err)
-						continue
-					}
-					lastSentTime = time.Now()
+		if err != nil && ctx.Err() != nil {
+			notice, keep := stopNotice(context.Cause(ctx), config.Lang)
+			if !keep {
+				// A newer message replaced this one, the partial answer stays visible
+				// but is not added to the history
+				logger.Info("Answer canceled", "response_id", responseID)
+				writer.Keyboard = nil
+				if err := writer.Update(messageText, true); err != nil {
+					logger.Error("Failed to show answer", "error", err)
 				}
-			} else {
-				log.Printf("Received empty response choices")
-				continue
+				return responseID, false
 			}
+			// The partial answer is kept like a finished one and marked as cut off
+			logger.Info("Answer stopped", "response_id", responseID, "cause", context.Cause(ctx))
+			writer.Keyboard = nil
+			if messageText != "" {
+				writer.Keyboard = AnswerKeyboard(config.Lang)
+			}
+			if err := writer.Update(messageText+"\n\n_"+notice+"_", true); err != nil {
+				logger.Error("Failed to show answer", "error", err)
+			}
+			if messageText == "" {
+				return responseID, false
+			}
+			user.AddTurn(message.Text, questionID, messageText, writer.MessageIDs)
+			return responseID, true
+		}
+		if err != nil {
+			logger.Error("Answer stream failed", "response_id", responseID, "error", err)
+			writer.Keyboard = nil
+			if err := writer.Update(messageText, true); err != nil {
+				logger.Error("Failed to show answer", "error", err)
+			}
+			replyText(ctx, bot, message, lang.Translate("answer.unavailable", config.Lang))
+			return responseID, false
+		}
+		if len(response.Choices) == 0 {
+			logger.Debug("Received empty response choices")
+			continue
+		}
+		if messageText == "" && response.Choices[0].Delta.Content != "" {
+			metrics.TimeToFirstToken.WithLabelValues(model).Observe(time.Since(started).Seconds())
+		}
+		messageText += response.Choices[0].Delta.Content
+		if err := writer.Update(messageText, false); err != nil {
+			logger.Error("Failed to show answer", "error", err)
 		}
-
 	}
 
 }
 
-func addVisionMessage(bot *tgbotapi.BotAPI, message *tgbotapi.Message, config *config.Config) openai.ChatCompletionMessage {
+func addVisionMessage(ctx context.Context, bot *tgbotapi.BotAPI, message *tgbotapi.Message, config *config.Config) openai.ChatCompletionMessage {
 	if len(message.Photo) > 0 {
 		// Assuming you want the largest photo size
 		photoSize := message.Photo[len(message.Photo)-1]
@@ -130,7 +185,7 @@ func addVisionMessage(bot *tgbotapi.BotAPI, message *tgbotapi.Message, config *c
 		// Download the photo
 		file, err := bot.GetFile(tgbotapi.FileConfig{FileID: fileID})
 		if err != nil {
-			log.Printf("Error getting file: %v", err)
+			logging.From(ctx).Error("Failed to get photo", "error", err)
 			return openai.ChatCompletionMessage{
 				Role:    openai.ChatMessageRoleUser,
 				Content: message.Text,
@@ -139,7 +194,7 @@ func addVisionMessage(bot *tgbotapi.BotAPI, message *tgbotapi.Message, config *c
 
 		// Access the file URL
 		fileURL := file.Link(bot.Token)
-		fmt.Println("Photo URL:", fileURL)
+		logging.From(ctx).Debug("Sending photo", "file_path", file.FilePath)
 		if message.Text == "" {
 			message.Text = config.VisionPrompt
 		}
@@ -169,42 +224,27 @@ func addVisionMessage(bot *tgbotapi.BotAPI, message *tgbotapi.Message, config *c
 
 }
 
-func handleChatGPTResponse(bot *tgbotapi.BotAPI, client *openai.Client, message *tgbotapi.Message, config *config.Config, user *user.UsageTracker) string {
-	messages := []openai.ChatCompletionMessage{
-		{
-			Role:    openai.ChatMessageRoleSystem,
-			Content: config.SystemPrompt,
-		},
-	}
-	for _, msg := range user.GetMessages() {
-		messages = append(messages, openai.ChatCompletionMessage{
-			Role:    msg.Role,
-			Content: msg.Content,
-		})
-	}
-	messages = append(messages, openai.ChatCompletionMessage{
-		Role:    openai.ChatMessageRoleUser,
-		Content: message.Text,
-	})
-
+// Complete answers a single question without history or streaming and returns the
+// answer and its generation ID. role limits the fallback models.
+func Complete(ctx context.Context, p provider.Provider, config *config.Config, role, model, prompt string, temperature float64, question string) (string, string, error) {
 	req := openai.ChatCompletionRequest{
-		Model:       config.Model.ModelName,
-		MaxTokens:   config.MaxTokens,
-		Temperature: float32(config.Model.Temperature),
-		Messages:    messages,
+		Model:            model,
+		FrequencyPenalty: float32(config.Model.FrequencyPenalty),
+		PresencePenalty:  float32(config.Model.PresencePenalty),
+		Temperature:      float32(temperature),
+		TopP:             float32(config.Model.TopP),
+		MaxTokens:        config.MaxTokens,
+		Messages: []openai.ChatCompletionMessage{
+			{Role: openai.ChatMessageRoleSystem, Content: prompt},
+			{Role: openai.ChatMessageRoleUser, Content: question},
+		},
 	}
-	ctx := context.Background()
-	resp, err := client.CreateChatCompletion(ctx, req)
+	resp, err := complete(ctx, p, config, role, req)
 	if err != nil {
-		log.Printf("ChatGPT request error: %v", err)
-		msg := tgbotapi.NewMessage(message.Chat.ID, "Error: "+err.Error())
-		bot.Send(msg)
-		return ""
+		return "", "", err
 	}
-
-	answer := resp.Choices[0].Message.Content
-	msg := tgbotapi.NewMessage(message.Chat.ID, answer)
-	user.AddMessage(openai.ChatMessageRoleAssistant, answer)
-	bot.Send(msg)
-	return resp.ID
+	if len(resp.Choices) == 0 {
+		return "", resp.ID, fmt.Errorf("model %s returned no answer", req.Model)
+	}
+	return resp.Choices[0].Message.Content, resp.ID, nil
 }
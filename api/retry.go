@@ -0,0 +1,135 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"github.com/sashabaranov/go-openai"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"openrouter-gpt-telegram-bot/config"
+	"openrouter-gpt-telegram-bot/logging"
+	"openrouter-gpt-telegram-bot/metrics"
+	"openrouter-gpt-telegram-bot/provider"
+	"strings"
+	"time"
+)
+
+// openStream starts a streaming chat completion with the retries and fallbacks of
+// tryModels. It returns the model the stream was opened with.
+func openStream(ctx context.Context, p provider.Provider, conf *config.Config, role string, req openai.ChatCompletionRequest) (provider.Stream, string, error) {
+	var stream provider.Stream
+	model, err := tryModels(ctx, conf, role, req.Model, func(ctx context.Context, model string) error {
+		req.Model = model
+		var err error
+		stream, err = p.ChatStream(ctx, req)
+		return err
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return stream, model, nil
+}
+
+// complete requests a chat completion with the retries and fallbacks of tryModels.
+func complete(ctx context.Context, p provider.Provider, conf *config.Config, role string, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	var resp openai.ChatCompletionResponse
+	_, err := tryModels(ctx, conf, role, req.Model, func(ctx context.Context, model string) error {
+		req.Model = model
+		var err error
+		resp, err = p.Chat(ctx, req)
+		return err
+	})
+	return resp, err
+}
+
+// tryModels calls request with model until it succeeds. Transient errors are retried with
+// exponential backoff, after that the fallback models that role may use are tried in order.
+// Other errors and a canceled ctx end the attempts. Providers with native fallback support
+// also get the fallback models with the request. It returns the model the request
+// succeeded with.
+func tryModels(ctx context.Context, conf *config.Config, role, model string, request func(ctx context.Context, model string) error) (string, error) {
+	models := []string{model}
+	for _, fallback := range conf.FallbackModels {
+		if fallback != model && conf.ModelAllowed(role, fallback) {
+			models = append(models, fallback)
+		}
+	}
+
+	var err error
+	for i, model := range models {
+		modelCtx := provider.WithFallbackModels(ctx, models[i+1:])
+		for attempt := 1; attempt <= conf.Retry.Attempts; attempt++ {
+			err = request(modelCtx, model)
+			if err == nil {
+				metrics.ProviderRequests.WithLabelValues(model, "success").Inc()
+				return model, nil
+			}
+			if ctx.Err() != nil {
+				return "", err
+			}
+			if !isTransient(err) || attempt == conf.Retry.Attempts {
+				metrics.ProviderRequests.WithLabelValues(model, "failed").Inc()
+			} else {
+				metrics.ProviderRequests.WithLabelValues(model, "retried").Inc()
+			}
+			if !isTransient(err) {
+				logging.From(ctx).Warn("Model failed", "model", model, "error", err)
+				return "", err
+			}
+			if attempt == conf.Retry.Attempts {
+				logging.From(ctx).Warn("Model failed after retries", "model", model, "attempts", attempt, "error", err)
+				break
+			}
+			delay := backoff(conf.Retry, attempt)
+			logging.From(ctx).Info("Model failed, retrying", "model", model, "attempt", attempt, "delay", delay, "error", err)
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return "", ctx.Err()
+			}
+		}
+	}
+	return "", err
+}
+
+// backoff returns the delay before the given retry, doubling from Delay up to MaxDelay.
+// Jitter spreads the delay over its upper half so clients do not retry in lockstep.
+func backoff(retry config.RetryParameters, attempt int) time.Duration {
+	delay := time.Duration(retry.Delay) * time.Millisecond << (attempt - 1)
+	if maxDelay := time.Duration(retry.MaxDelay) * time.Millisecond; delay > maxDelay || delay <= 0 {
+		delay = maxDelay
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// isTransient reports whether an error is worth retrying: rate limits, server errors
+// and network failures.
+func isTransient(err error) bool {
+	var apiErr *openai.APIError
+	if errors.As(err, &apiErr) {
+		return transientStatus(apiErr.HTTPStatusCode)
+	}
+	var reqErr *openai.RequestError
+	if errors.As(err, &reqErr) {
+		return transientStatus(reqErr.HTTPStatusCode)
+	}
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		strings.Contains(err.Error(), "connection reset")
+}
+
+func transientStatus(code int) bool {
+	return code == http.StatusTooManyRequests || code >= http.StatusInternalServerError
+}
+
+// sameModel reports whether the model reported in a response is the requested one.
+// Providers may answer with a dated variant such as gpt-4o-2024-05-13 for gpt-4o.
+func sameModel(requested, answered string) bool {
+	return answered == "" || strings.HasPrefix(answered, requested) ||
+		strings.HasPrefix(requested, answered)
+}
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"github.com/sashabaranov/go-openai"
+	"net/http"
+	"openrouter-gpt-telegram-bot/config"
+	"reflect"
+	"testing"
+)
+
+func TestTryModels(t *testing.T) {
+	conf := &config.Config{
+		Model:          config.ModelParameters{ModelName: "main"},
+		FallbackModels: []string{"allowed", "other"},
+		AllowedModels:  map[string][]string{"USER": {"allowed"}},
+		Retry:          config.RetryParameters{Attempts: 2, Delay: 1, MaxDelay: 1},
+	}
+	unavailable := &openai.APIError{HTTPStatusCode: http.StatusServiceUnavailable}
+	badRequest := &openai.APIError{HTTPStatusCode: http.StatusBadRequest}
+	tests := []struct {
+		name  string
+		role  string
+		err   error
+		tried []string
+	}{
+		{"transient error tries allowed fallbacks", "USER", unavailable, []string{"main", "main", "allowed", "allowed"}},
+		{"fallbacks of the role", "ADMIN", unavailable, []string{"main", "main"}},
+		{"other errors are not retried", "USER", badRequest, []string{"main"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var tried []string
+			_, err := tryModels(context.Background(), conf, tt.role, "main", func(ctx context.Context, model string) error {
+				tried = append(tried, model)
+				return tt.err
+			})
+			if !errors.Is(err, tt.err) {
+				t.Errorf("tryModels() error = %v, want %v", err, tt.err)
+			}
+			if !reflect.DeepEqual(tried, tt.tried) {
+				t.Errorf("tried %v, want %v", tried, tt.tried)
+			}
+		})
+	}
+}
+
+func TestTryModelsStopsWhenCanceled(t *testing.T) {
+	conf := &config.Config{
+		FallbackModels: []string{"fallback"},
+		AllowedModels:  map[string][]string{"ADMIN": {"*"}},
+		Retry:          config.RetryParameters{Attempts: 1, Delay: 1, MaxDelay: 1},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	_, err := tryModels(ctx, conf, "ADMIN", "main", func(ctx context.Context, model string) error {
+		calls++
+		cancel()
+		return &openai.APIError{HTTPStatusCode: http.StatusServiceUnavailable}
+	})
+	if err == nil || calls != 1 {
+		t.Errorf("tryModels() = %v after %d calls, want an error after 1", err, calls)
+	}
+}
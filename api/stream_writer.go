@@ -0,0 +1,245 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"openrouter-gpt-telegram-bot/logging"
+	"strings"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+const (
+	// maxMessageLength is the Telegram limit for message text in UTF-16 code units.
+	maxMessageLength = 4096
+	// partLength leaves room for the fence closing a split code block and for
+	// Markdown that renders longer than it is written.
+	partLength = 3900
+	// editInterval throttles message edits while an answer streams in.
+	editInterval = 800 * time.Millisecond
+)
+
+// streamWriter shows a streamed answer in Telegram. Text beyond the message length limit
+// rolls over into continuation messages, split at paragraph or line boundaries, and only
+// the last message is edited as more text arrives.
+type streamWriter struct {
+	ctx     context.Context
+	bot     *tgbotapi.BotAPI
+	message *tgbotapi.Message
+	// frozen is the length of the answer prefix in finished messages
+	frozen int
+	// carry reopens a code block split across messages at the start of the tail message
+	carry        string
+	tailID       int
+	tailText     string
+	tailKeyboard *tgbotapi.InlineKeyboardMarkup
+	// tailReused is set while the tail message is one of reuse that was not edited yet
+	tailReused bool
+	lastSent   time.Time
+	// reuse are messages of an earlier answer that are edited before new messages are sent
+	reuse []int
+	// Keyboard is attached to the tail message, finished messages have none
+	Keyboard *tgbotapi.InlineKeyboardMarkup
+	// MessageIDs are the IDs of all messages of the answer in order
+	MessageIDs []int
+}
+
+func newStreamWriter(ctx context.Context, bot *tgbotapi.BotAPI, message *tgbotapi.Message) *streamWriter {
+	return &streamWriter{ctx: ctx, bot: bot, message: message}
+}
+
+// Update shows the answer so far. Edits are throttled unless final is set.
+func (w *streamWriter) Update(answer string, final bool) error {
+	for {
+		tail := w.carry + answer[w.frozen:]
+		if utf16Len(tail) <= partLength {
+			break
+		}
+		cut, fenceLang, inFence := splitPoint(tail, partLength)
+		part := tail[:cut]
+		nextCarry := ""
+		if inFence {
+			part = strings.TrimRight(part, "\n") + "\n```"
+			nextCarry = "```" + fenceLang + "\n"
+		}
+		if err := w.show(part, nil); err != nil {
+			return err
+		}
+		w.frozen += cut - len(w.carry)
+		w.carry = nextCarry
+		w.tailID = 0
+		w.tailText = ""
+	}
+
+	tail := w.carry + answer[w.frozen:]
+	if strings.TrimSpace(tail) == "" {
+		return nil
+	}
+	if !final && w.tailID != 0 && time.Since(w.lastSent) < editInterval {
+		return nil
+	}
+	if err := w.show(tail, w.Keyboard); err != nil {
+		return err
+	}
+	if final {
+		w.deleteUnused()
+	}
+	return nil
+}
+
+// show sends text as the tail message, or edits the tail message if it was sent already.
+func (w *streamWriter) show(text string, keyboard *tgbotapi.InlineKeyboardMarkup) error {
+	if text == w.tailText && keyboard == w.tailKeyboard {
+		// Telegram rejects edits that do not change the message
+		return nil
+	}
+	if w.tailID == 0 && len(w.reuse) > 0 {
+		w.tailID, w.reuse = w.reuse[0], w.reuse[1:]
+		w.tailReused = true
+		w.MessageIDs = append(w.MessageIDs, w.tailID)
+	}
+	if w.tailID != 0 {
+		err := editRendered(w.ctx, w.bot, w.message.Chat.ID, w.tailID, text, keyboard)
+		if err != nil && isNotModified(err) {
+			// A reused message may already show the text
+			err = nil
+		}
+		if err != nil && w.tailReused {
+			// The earlier answer may have been deleted, the rest is sent as new messages
+			logging.From(w.ctx).Warn("Failed to edit earlier answer, sending a new message", "error", err)
+			w.MessageIDs = w.MessageIDs[:len(w.MessageIDs)-1]
+			w.tailID, w.tailReused, w.reuse = 0, false, nil
+			return w.show(text, keyboard)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to edit message: %w", err)
+		}
+		w.tailReused = false
+	} else {
+		msg := tgbotapi.NewMessage(w.message.Chat.ID, text)
+		if keyboard != nil {
+			msg.ReplyMarkup = keyboard
+		}
+		if !w.message.Chat.IsPrivate() {
+			// Replying keeps the answer in the forum topic of the question
+			msg.ReplyToMessageID = w.message.MessageID
+			if len(w.MessageIDs) > 0 {
+				msg.ReplyToMessageID = w.MessageIDs[len(w.MessageIDs)-1]
+			}
+		}
+		sent, err := sendRendered(w.ctx, w.bot, msg)
+		if err != nil {
+			return fmt.Errorf("failed to send message: %w", err)
+		}
+		w.tailID = sent.MessageID
+		w.MessageIDs = append(w.MessageIDs, sent.MessageID)
+	}
+	w.tailText = text
+	w.tailKeyboard = keyboard
+	w.lastSent = time.Now()
+	return nil
+}
+
+// deleteUnused deletes the messages of the earlier answer the new answer did not need.
+func (w *streamWriter) deleteUnused() {
+	for _, id := range w.reuse {
+		if _, err := w.bot.Request(tgbotapi.NewDeleteMessage(w.message.Chat.ID, id)); err != nil {
+			logging.From(w.ctx).Warn("Failed to delete earlier answer", "message_id", id, "error", err)
+		}
+	}
+	w.reuse = nil
+}
+
+// SendDocument sends the whole answer as a Markdown file.
+func (w *streamWriter) SendDocument(answer string) {
+	doc := tgbotapi.NewDocument(w.message.Chat.ID, tgbotapi.FileBytes{Name: "answer.md", Bytes: []byte(answer)})
+	if len(w.MessageIDs) > 0 {
+		doc.ReplyToMessageID = w.MessageIDs[0]
+	}
+	if _, err := w.bot.Send(doc); err != nil {
+		logging.From(w.ctx).Error("Failed to send answer document", "error", err)
+	}
+}
+
+// splitPoint returns where to split text so the first part is at most limit UTF-16 code
+// units long. It prefers the last paragraph break outside code blocks, then the last line
+// break outside code blocks, then the last line break inside a code block, then the last
+// space. If the split is
+// inside a code block, the block's language is returned with inFence set.
+func splitPoint(text string, limit int) (cut int, fenceLang string, inFence bool) {
+	maxCut := prefixWithin(text, limit)
+
+	paragraph, line, codeLine := 0, 0, 0
+	codeLineLang := ""
+	fence, lang := false, ""
+	for i := 0; i < maxCut; {
+		end := strings.IndexByte(text[i:maxCut], '\n')
+		if end == -1 {
+			break
+		}
+		lineText := strings.TrimSpace(text[i : i+end])
+		next := i + end + 1
+		if strings.HasPrefix(lineText, "```") {
+			fence = !fence
+			if fence {
+				lang = strings.TrimSpace(strings.TrimPrefix(lineText, "```"))
+			}
+		}
+		switch {
+		case fence:
+			codeLine, codeLineLang = next, lang
+		case lineText == "":
+			paragraph = next
+		default:
+			line = next
+		}
+		i = next
+	}
+
+	// Splitting far from the limit would produce tiny messages
+	switch {
+	case paragraph > maxCut/2:
+		return paragraph, "", false
+	case line > maxCut/2:
+		return line, "", false
+	case codeLine > maxCut/4:
+		return codeLine, codeLineLang, true
+	}
+	if space := strings.LastIndexByte(text[:maxCut], ' '); space > maxCut/2 {
+		maxCut = space + 1
+	}
+	return maxCut, lang, fenceOpenAt(text, maxCut)
+}
+
+// fenceOpenAt reports whether a code block is open at byte offset i of text.
+func fenceOpenAt(text string, i int) bool {
+	open := false
+	for _, line := range strings.Split(text[:i], "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			open = !open
+		}
+	}
+	return open
+}
+
+// prefixWithin returns the length in bytes of the longest prefix of text that is at most
+// limit UTF-16 code units long and ends on a rune boundary.
+func prefixWithin(text string, limit int) int {
+	units := 0
+	for i, r := range text {
+		units += len(utf16.Encode([]rune{r}))
+		if units > limit {
+			return i
+		}
+	}
+	return len(text)
+}
+
+func utf16Len(text string) int {
+	if utf8.RuneCountInString(text) == len(text) {
+		return len(text)
+	}
+	return len(utf16.Encode([]rune(text)))
+}
@@ -0,0 +1,87 @@
+package api
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPrefixWithin(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		limit int
+		want  int
+	}{
+		{"short text", "abc", 10, 3},
+		{"ascii", "abcdef", 4, 4},
+		{"cyrillic takes one unit per rune", "привет", 3, 6},
+		{"emoji takes two units", "a😀b", 2, 1},
+		{"emoji fits", "a😀b", 3, 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := prefixWithin(tt.text, tt.limit); got != tt.want {
+				t.Errorf("prefixWithin(%q, %d) = %d, want %d", tt.text, tt.limit, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitPoint(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		limit    int
+		wantCut  int
+		wantLang string
+		wantOpen bool
+	}{
+		{
+			name:    "paragraph break",
+			text:    "first line\nsecond line\n\nthird paragraph goes on",
+			limit:   30,
+			wantCut: len("first line\nsecond line\n\n"),
+		},
+		{
+			name:    "line break",
+			text:    "first line here\nsecond line goes on and on",
+			limit:   20,
+			wantCut: len("first line here\n"),
+		},
+		{
+			name:    "space",
+			text:    "words without any line breaks at all",
+			limit:   20,
+			wantCut: len("words without any "),
+		},
+		{
+			name:    "hard cut",
+			text:    strings.Repeat("x", 30),
+			limit:   20,
+			wantCut: 20,
+		},
+		{
+			name:     "inside code block",
+			text:     "```go\na := 1\nb := 2\nc := 3\nd := 4\n```",
+			limit:    24,
+			wantCut:  len("```go\na := 1\nb := 2\n"),
+			wantLang: "go",
+			wantOpen: true,
+		},
+		{
+			name:    "after code block",
+			text:    "```\ncode\n```\ntext after the code block",
+			limit:   20,
+			wantCut: len("```\ncode\n```\n"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cut, lang, open := splitPoint(tt.text, tt.limit)
+			if cut != tt.wantCut || lang != tt.wantLang || open != tt.wantOpen {
+				t.Errorf("splitPoint(%q, %d) = %d, %q, %v, want %d, %q, %v",
+					tt.text, tt.limit, cut, lang, open, tt.wantCut, tt.wantLang, tt.wantOpen)
+			}
+		})
+	}
+}
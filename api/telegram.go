@@ -0,0 +1,98 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"io"
+	"net/http"
+	"openrouter-gpt-telegram-bot/logging"
+	"openrouter-gpt-telegram-bot/render"
+	"strings"
+	"time"
+)
+
+// downloadClient downloads files sent to the bot. Telegram serves files of up to 20 MB.
+var downloadClient = &http.Client{Timeout: time.Minute}
+
+// isParseError reports whether Telegram rejected a message because of its markup.
+func isParseError(err error) bool {
+	return strings.Contains(err.Error(), "can't parse entities")
+}
+
+// isNotModified reports whether Telegram rejected an edit because it would not change the message.
+func isNotModified(err error) bool {
+	return strings.Contains(err.Error(), "message is not modified")
+}
+
+// sendRendered sends Markdown text rendered as HTML. If Telegram rejects the markup,
+// the text is sent again as plain text.
+func sendRendered(ctx context.Context, bot *tgbotapi.BotAPI, msg tgbotapi.MessageConfig) (tgbotapi.Message, error) {
+	text := msg.Text
+	msg.Text = render.ToHTML(text)
+	msg.ParseMode = tgbotapi.ModeHTML
+	sent, err := bot.Send(msg)
+	if err != nil && isParseError(err) {
+		logging.From(ctx).Warn("Falling back to plain text", "error", err)
+		msg.Text = text
+		msg.ParseMode = ""
+		sent, err = bot.Send(msg)
+	}
+	return sent, err
+}
+
+// editRendered replaces the text of a message with Markdown text rendered as HTML,
+// falling back to plain text like sendRendered. The inline keyboard of the message is
+// replaced with keyboard, or removed if keyboard is nil.
+func editRendered(ctx context.Context, bot *tgbotapi.BotAPI, chatID int64, messageID int, text string, keyboard *tgbotapi.InlineKeyboardMarkup) error {
+	edit := tgbotapi.NewEditMessageText(chatID, messageID, render.ToHTML(text))
+	edit.ParseMode = tgbotapi.ModeHTML
+	edit.ReplyMarkup = keyboard
+	_, err := bot.Send(edit)
+	if err != nil && isParseError(err) {
+		logging.From(ctx).Warn("Falling back to plain text", "error", err)
+		edit.Text = text
+		edit.ParseMode = ""
+		_, err = bot.Send(edit)
+	}
+	return err
+}
+
+// replyText sends plain text to the chat of message, as a reply outside private chats
+// so it stays in the forum topic.
+func replyText(ctx context.Context, bot *tgbotapi.BotAPI, message *tgbotapi.Message, text string) {
+	msg := tgbotapi.NewMessage(message.Chat.ID, text)
+	if !message.Chat.IsPrivate() {
+		msg.ReplyToMessageID = message.MessageID
+	}
+	if _, err := bot.Send(msg); err != nil {
+		logging.From(ctx).Error("Failed to send message", "error", err)
+	}
+}
+
+// DownloadFile downloads a file sent to the bot and returns its content and its path
+// on the Telegram server. The download stops when ctx is canceled.
+func DownloadFile(ctx context.Context, bot *tgbotapi.BotAPI, fileID string) ([]byte, string, error) {
+	file, err := bot.GetFile(tgbotapi.FileConfig{FileID: fileID})
+	if err != nil {
+		return nil, "", fmt.Errorf("getting file: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, file.Link(bot.Token), nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("downloading file %s failed", file.FilePath)
+	}
+	resp, err := downloadClient.Do(req)
+	if err != nil {
+		// The error contains the file URL and with it the bot token
+		return nil, "", fmt.Errorf("downloading file %s failed", file.FilePath)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("downloading file %s: %s", file.FilePath, resp.Status)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("downloading file %s: %w", file.FilePath, err)
+	}
+	return data, file.FilePath, nil
+}
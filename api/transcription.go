@@ -0,0 +1,60 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/sashabaranov/go-openai"
+	"openrouter-gpt-telegram-bot/config"
+	"path"
+	"strings"
+	"time"
+)
+
+// IsAudio reports whether message is a voice note or an audio file.
+func IsAudio(message *tgbotapi.Message) bool {
+	return message.Voice != nil || message.Audio != nil
+}
+
+// Transcribe converts a voice note or audio file to text with the configured transcription
+// endpoint. It returns the text and its cost in USD.
+func Transcribe(ctx context.Context, bot *tgbotapi.BotAPI, conf *config.Config, message *tgbotapi.Message) (string, float64, error) {
+	var fileID, name string
+	var duration int
+	switch {
+	case message.Voice != nil:
+		// Telegram names voice notes .oga, which transcription APIs do not accept
+		fileID, name, duration = message.Voice.FileID, "voice.ogg", message.Voice.Duration
+	case message.Audio != nil:
+		fileID, name, duration = message.Audio.FileID, message.Audio.FileName, message.Audio.Duration
+	default:
+		return "", 0, fmt.Errorf("message has no audio")
+	}
+
+	data, filePath, err := DownloadFile(ctx, bot, fileID)
+	if err != nil {
+		return "", 0, err
+	}
+	if name == "" {
+		name = path.Base(filePath)
+	}
+
+	clientOptions := openai.DefaultConfig(conf.Transcription.APIKey)
+	clientOptions.BaseURL = conf.Transcription.BaseURL
+	client := openai.NewClientWithConfig(clientOptions)
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Minute)
+	defer cancel()
+	result, err := client.CreateTranscription(ctx, openai.AudioRequest{
+		Model:    conf.Transcription.Model,
+		FilePath: name,
+		Reader:   bytes.NewReader(data),
+		Language: conf.Transcription.Language,
+	})
+	if err != nil {
+		return "", 0, fmt.Errorf("transcribing: %w", err)
+	}
+
+	cost := float64(duration) / 60 * conf.Transcription.PricePerMinute
+	return strings.TrimSpace(result.Text), cost, nil
+}
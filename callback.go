@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"openrouter-gpt-telegram-bot/logging"
+	"openrouter-gpt-telegram-bot/transport"
+	"strings"
+)
+
+// handleCallback routes inline keyboard presses by the action prefix of the callback data,
+// which has the form action:payload.
+func (d *Dispatcher) handleCallback(ctx context.Context, update transport.Update) {
+	query := update.CallbackQuery
+	if query.Message == nil || query.From == nil {
+		// Buttons of inline mode messages are not used
+		d.answerCallback(ctx, query, "")
+		return
+	}
+
+	action, payload, _ := strings.Cut(query.Data, ":")
+	switch action {
+	case "model":
+		d.handleModelCallback(ctx, update, payload)
+	case "persona":
+		d.handlePersonaCallback(ctx, update, payload)
+	case "answer":
+		d.handleAnswerCallback(ctx, update, payload)
+	default:
+		logging.From(ctx).Warn("Unknown callback action", "action", action)
+		d.answerCallback(ctx, query, "")
+	}
+}
+
+// answerCallback stops the loading indicator on the pressed button, showing text as a toast if set.
+func (d *Dispatcher) answerCallback(ctx context.Context, query *tgbotapi.CallbackQuery, text string) {
+	_, err := d.bot.Request(tgbotapi.NewCallback(query.ID, text))
+	if err != nil {
+		logging.From(ctx).Error("Failed to answer callback query", "error", err)
+	}
+}
@@ -1,12 +1,14 @@
 package config
 
 import (
+    "errors"
     "fmt"
+    tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
     "github.com/sashabaranov/go-openai"
     "github.com/spf13/viper"
-    "log"
+    "log/slog"
     "openrouter-gpt-telegram-bot/lang"
-    "os"
+    "sort"
     "strconv"
     "strings"
 )
@@ -31,6 +33,143 @@ type Config struct {
     VisionDetails     string
     StatsMinRole      string
     Lang              string
+    TelegramAPIEndpoint string
+    Transport         TransportParameters
+    History           HistoryParameters
+    Group             GroupParameters
+    // AllowedModels lists the models each role (ADMIN, USER, GUEST) may pick with /model
+    AllowedModels     map[string][]string
+    // ContextBudget is the number of prompt and answer tokens a request may use,
+    // ContextBudgets overrides it per model
+    ContextBudget     int
+    ContextBudgets    map[string]int
+    // HistoryOverflow is what happens to turns that do not fit the budget: drop or summarize
+    HistoryOverflow   string
+    // LongAnswerDocument is the answer length in characters above which the answer is
+    // also sent as a .md file, 0 disables it
+    LongAnswerDocument int
+    Transcription     TranscriptionParameters
+    // DocumentMaxSize (in KB) and DocumentMaxTokens limit uploaded documents per role,
+    // a role without a limit cannot upload documents
+    DocumentMaxSize   map[string]int
+    DocumentMaxTokens map[string]int
+    Image             ImageParameters
+    Retry             RetryParameters
+    // FallbackModels are tried in order when the selected model keeps failing
+    FallbackModels    []string
+    Metrics           MetricsParameters
+    // LogLevel is debug, info, warn or error, LogFormat is text or json
+    LogLevel          string
+    LogFormat         string
+    RateLimit         RateLimitParameters
+    // MessagePolicy is what happens to a message sent while the conversation is busy
+    // with an answer: queue, cancel (the running answer) or reject
+    MessagePolicy     string
+    // EditPolicy is what happens when a question older than the latest one is edited:
+    // latest (the edit is ignored) or branch (later turns are dropped and it is answered again)
+    EditPolicy        string
+    Inline            InlineParameters
+    // Personas are the presets users pick with /persona, sorted by ID
+    Personas          []Persona
+    // ShutdownTimeout is how many seconds running answers may take to finish on shutdown
+    ShutdownTimeout   int
+}
+
+type RateLimitParameters struct {
+    // PerUser and Burst are the requests per minute and the burst size of each user by role,
+    // PerRole is the requests per minute shared by all users of a role. Missing roles are unlimited.
+    PerUser map[string]int
+    Burst   map[string]int
+    PerRole map[string]int
+    // MaxStreams caps concurrent provider streams, 0 is unlimited. Requests beyond it wait
+    // in a queue of QueueSize for at most QueueTimeout seconds.
+    MaxStreams   int
+    QueueSize    int
+    QueueTimeout int
+}
+
+type MetricsParameters struct {
+    // Listen is the address of the Prometheus metrics server, empty disables it
+    Listen string
+    Path   string
+}
+
+type RetryParameters struct {
+    // Attempts is how often a request to one model is tried
+    Attempts int
+    // Delay is the backoff before the first retry in milliseconds, it doubles with every
+    // retry up to MaxDelay
+    Delay    int
+    MaxDelay int
+}
+
+type ImageParameters struct {
+    // Model generates images for /image, empty disables the command
+    Model string
+    Size  string
+    // Roles may use /image
+    Roles []string
+    // Price is charged per image when the provider does not report the cost
+    Price float64
+}
+
+// Persona is a named preset of system prompt, model and temperature.
+type Persona struct {
+    // ID is the key of the persona in the config file, Name is shown to users and defaults to ID
+    ID     string
+    Name   string
+    Prompt string
+    // Model and Temperature override the defaults when set
+    Model       string
+    Temperature *float64
+    // Greeting is sent when the persona is picked
+    Greeting string
+}
+
+type InlineParameters struct {
+    // Enabled answers inline queries, inline mode must also be enabled with @BotFather
+    Enabled bool
+    // Debounce is how many milliseconds a query must stay unchanged before it is answered
+    Debounce int
+    // CacheTTL is how many seconds answers are reused for the same question, CacheSize
+    // how many answers are kept
+    CacheTTL  int
+    CacheSize int
+}
+
+type TranscriptionParameters struct {
+    Enabled bool
+    // BaseURL and APIKey of the OpenAI-compatible transcription endpoint. They are separate
+    // from BASE_URL and API_KEY, as OpenRouter has no transcription API.
+    BaseURL  string
+    APIKey   string
+    Model    string
+    // Language is an optional ISO-639-1 hint such as en
+    Language string
+    // PricePerMinute is the cost in USD charged per minute of audio
+    PricePerMinute float64
+}
+
+type GroupParameters struct {
+    // TriggerWord makes the bot answer group messages starting with it, in addition to mentions and replies
+    TriggerWord string
+    // Billing is who pays for answers in groups: sender or chat
+    Billing string
+}
+
+type HistoryParameters struct {
+    Store string
+    Path  string
+}
+
+type TransportParameters struct {
+    Mode        string
+    WebhookURL  string
+    ListenAddr  string
+    Path        string
+    SecretToken string
+    TLSCertFile string
+    TLSKeyFile  string
 }
 
 type ModelParameters struct {
@@ -47,9 +186,72 @@ type ModelParameters struct {
     TopP              float64
 }
 
+// loadErrors collects values that could not be parsed during Load. Load is only
+// called at startup and from the config watcher, never concurrently.
+var loadErrors []error
+
+// getValue gets a value as a string from environment variables, which take precedence,
+// or from the config file. YAML lists and maps are joined with commas.
+func getValue(key string) string {
+    switch value := viper.Get(key).(type) {
+    case nil:
+        return ""
+    case []interface{}:
+        items := make([]string, 0, len(value))
+        for _, item := range value {
+            items = append(items, fmt.Sprint(item))
+        }
+        return strings.Join(items, ",")
+    case map[string]interface{}:
+        // YAML maps such as rate_limit: {USER: 20} become key=value lists
+        items := make([]string, 0, len(value))
+        for k, v := range value {
+            items = append(items, fmt.Sprintf("%s=%v", k, v))
+        }
+        sort.Strings(items)
+        return strings.Join(items, ",")
+    default:
+        return viper.GetString(key)
+    }
+}
+
+// getPersonas reads the personas map of the config file. They cannot be set with
+// environment variables.
+func getPersonas() []Persona {
+    var raw map[string]struct {
+        Name        string   `mapstructure:"name"`
+        Prompt      string   `mapstructure:"prompt"`
+        Model       string   `mapstructure:"model"`
+        Temperature *float64 `mapstructure:"temperature"`
+        Greeting    string   `mapstructure:"greeting"`
+    }
+    if err := viper.UnmarshalKey("personas", &raw); err != nil {
+        loadErrors = append(loadErrors, fmt.Errorf("could not parse personas: %w", err))
+        return nil
+    }
+
+    var personas []Persona
+    for id, p := range raw {
+        name := p.Name
+        if name == "" {
+            name = id
+        }
+        personas = append(personas, Persona{
+            ID:          id,
+            Name:        name,
+            Prompt:      strings.TrimSpace(p.Prompt),
+            Model:       p.Model,
+            Temperature: p.Temperature,
+            Greeting:    strings.TrimSpace(p.Greeting),
+        })
+    }
+    sort.Slice(personas, func(i, j int) bool { return personas[i].ID < personas[j].ID })
+    return personas
+}
+
 // getStrAsIntList converts a comma-separated string of numbers to []int64
 func getStrAsIntList(envKey string) []int64 {
-    str := os.Getenv(envKey)
+    str := getValue(envKey)
     if str == "" {
         return []int64{}
     }
@@ -63,7 +265,7 @@ func getStrAsIntList(envKey string) []int64 {
         }
         i, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
         if err != nil {
-            log.Printf("Warning: could not parse %s as int64: %v", s, err)
+            loadErrors = append(loadErrors, fmt.Errorf("could not parse %s entry %s as int64: %w", envKey, s, err))
             continue
         }
         intList = append(intList, i)
@@ -71,45 +273,87 @@ func getStrAsIntList(envKey string) []int64 {
     return intList
 }
 
-// getEnvString gets a string from environment variables with a default value
+// getStrList converts a comma-separated string to []string, skipping empty entries
+func getStrList(envKey string) []string {
+    var list []string
+    for _, s := range strings.Split(getValue(envKey), ",") {
+        s = strings.TrimSpace(s)
+        if s != "" {
+            list = append(list, s)
+        }
+    }
+    return list
+}
+
+// getStrIntMap converts a comma-separated list of key=number pairs to map[string]int
+func getStrIntMap(envKey string) map[string]int {
+    result := make(map[string]int)
+    for _, pair := range getStrList(envKey) {
+        key, value, ok := strings.Cut(pair, "=")
+        if !ok {
+            loadErrors = append(loadErrors, fmt.Errorf("%s entry %q is not in key=value form", envKey, pair))
+            continue
+        }
+        i, err := strconv.Atoi(strings.TrimSpace(value))
+        if err != nil {
+            loadErrors = append(loadErrors, fmt.Errorf("could not parse %s value %s as int: %w", envKey, value, err))
+            continue
+        }
+        result[strings.TrimSpace(key)] = i
+    }
+    return result
+}
+
+// getRoleIntMap is getStrIntMap with the keys, which are roles, in upper case
+func getRoleIntMap(envKey string) map[string]int {
+    result := make(map[string]int)
+    for role, value := range getStrIntMap(envKey) {
+        result[strings.ToUpper(role)] = value
+    }
+    return result
+}
+
+// getEnvString gets a string from environment variables or the config file with a default value
 func getEnvString(key string, defaultValue string) string {
-    value := os.Getenv(key)
+    value := getValue(key)
     if value == "" {
         return defaultValue
     }
     return value
 }
 
-// getEnvInt gets an integer from environment variables with a default value
+// getEnvInt gets an integer from environment variables or the config file with a default value
 func getEnvInt(key string, defaultValue int) int {
-    value := os.Getenv(key)
+    value := getValue(key)
     if value == "" {
         return defaultValue
     }
     intValue, err := strconv.Atoi(value)
     if err != nil {
-        log.Printf("Warning: could not parse %s as int: %v, using default value %d", key, err, defaultValue)
+        loadErrors = append(loadErrors, fmt.Errorf("could not parse %s as int: %w", key, err))
         return defaultValue
     }
     return intValue
 }
 
-// getEnvFloat gets a float64 from environment variables with a default value
+// getEnvFloat gets a float64 from environment variables or the config file with a default value
 func getEnvFloat(key string, defaultValue float64) float64 {
-    value := os.Getenv(key)
+    value := getValue(key)
     if value == "" {
         return defaultValue
     }
     floatValue, err := strconv.ParseFloat(value, 64)
     if err != nil {
-        log.Printf("Warning: could not parse %s as float64: %v, using default value %f", key, err, defaultValue)
+        loadErrors = append(loadErrors, fmt.Errorf("could not parse %s as float64: %w", key, err))
         return defaultValue
     }
     return floatValue
 }
 
-// Load initializes and returns the configuration
+// Load initializes and returns the configuration from environment variables and the config file
 func Load() (*Config, error) {
+    loadErrors = nil
+
     // Set default values
     viper.SetDefault("MAX_TOKENS", 2000)
     viper.SetDefault("TEMPERATURE", 1)
@@ -119,14 +363,37 @@ func Load() (*Config, error) {
     viper.SetDefault("MAX_HISTORY_SIZE", 10)
     viper.SetDefault("MAX_HISTORY_TIME", 60)
     viper.SetDefault("LANG", "en")
+    viper.SetDefault("TRANSPORT", "polling")
+    viper.SetDefault("WEBHOOK_LISTEN", ":8080")
+    viper.SetDefault("WEBHOOK_PATH", "/telegram")
+    viper.SetDefault("HISTORY_STORE", "bolt")
+    viper.SetDefault("GROUP_BILLING", "sender")
+    viper.SetDefault("CONTEXT_BUDGET", 16000)
+    viper.SetDefault("HISTORY_OVERFLOW", "drop")
+    viper.SetDefault("DOCUMENT_MAX_SIZE", "ADMIN=20480,USER=5120,GUEST=1024")
+    viper.SetDefault("DOCUMENT_MAX_TOKENS", "ADMIN=32000,USER=16000,GUEST=4000")
+    viper.SetDefault("IMAGE_ROLES", "ADMIN,USER")
+    viper.SetDefault("METRICS_PATH", "/metrics")
+    viper.SetDefault("RATE_LIMIT", "USER=20,GUEST=5")
+    viper.SetDefault("RATE_BURST", "USER=5,GUEST=3")
+    viper.SetDefault("MAX_CONCURRENT_STREAMS", 8)
+    viper.SetDefault("STREAM_QUEUE_SIZE", 32)
+    viper.SetDefault("STREAM_QUEUE_TIMEOUT", 120)
+    viper.SetDefault("RETRY_ATTEMPTS", 3)
+    viper.SetDefault("RETRY_DELAY", 500)
+    viper.SetDefault("RETRY_MAX_DELAY", 8000)
+    viper.SetDefault("SHUTDOWN_TIMEOUT", 30)
+    viper.SetDefault("INLINE_DEBOUNCE", 1000)
+    viper.SetDefault("INLINE_CACHE_TTL", 600)
+    viper.SetDefault("INLINE_CACHE_SIZE", 500)
 
     // Initialize configuration
     config := &Config{
-        TelegramBotToken: os.Getenv("TELEGRAM_BOT_TOKEN"),
-        OpenAIApiKey:     os.Getenv("API_KEY"),
+        TelegramBotToken: getValue("TELEGRAM_BOT_TOKEN"),
+        OpenAIApiKey:     getValue("API_KEY"),
         Model: ModelParameters{
-            Type:              os.Getenv("TYPE"),
-            ModelName:         os.Getenv("MODEL"),
+            Type:              getValue("TYPE"),
+            ModelName:         getValue("MODEL"),
             Temperature:       getEnvFloat("TEMPERATURE", 1.0),
             TopP:             getEnvFloat("TOP_P", 0.7),
             FrequencyPenalty: getEnvFloat("FREQUENCY_PENALTY", 0),
@@ -138,7 +405,7 @@ func Load() (*Config, error) {
         },
         MaxTokens:          getEnvInt("MAX_TOKENS", 2000),
         OpenAIBaseURL:      getEnvString("BASE_URL", "https://api.openai.com/v1"),
-        SystemPrompt:       os.Getenv("ASSISTANT_PROMPT"),
+        SystemPrompt:       getValue("ASSISTANT_PROMPT"),
         BudgetPeriod:       getEnvString("BUDGET_PERIOD", "monthly"),
         GuestBudget:        getEnvFloat("GUEST_BUDGET", 0),
         UserBudget:         getEnvFloat("USER_BUDGET", 0),
@@ -146,11 +413,88 @@ func Load() (*Config, error) {
         AllowedUserChatIDs: getStrAsIntList("ALLOWED_USER_IDS"),
         MaxHistorySize:     getEnvInt("MAX_HISTORY_SIZE", 10),
         MaxHistoryTime:     getEnvInt("MAX_HISTORY_TIME", 60),
-        Vision:             os.Getenv("VISION"),
-        VisionPrompt:       os.Getenv("VISION_PROMPT"),
-        VisionDetails:      os.Getenv("VISION_DETAIL"),
+        Vision:             getValue("VISION"),
+        VisionPrompt:       getValue("VISION_PROMPT"),
+        VisionDetails:      getValue("VISION_DETAIL"),
         StatsMinRole:       getEnvString("STATS_MIN_ROLE", "user"),
         Lang:               getEnvString("LANG", "en"),
+        TelegramAPIEndpoint: getEnvString("TELEGRAM_API_ENDPOINT", tgbotapi.APIEndpoint),
+        Transport: TransportParameters{
+            Mode:        getEnvString("TRANSPORT", "polling"),
+            WebhookURL:  getValue("WEBHOOK_URL"),
+            ListenAddr:  getEnvString("WEBHOOK_LISTEN", ":8080"),
+            Path:        getEnvString("WEBHOOK_PATH", "/telegram"),
+            SecretToken: getValue("WEBHOOK_SECRET"),
+            TLSCertFile: getValue("WEBHOOK_TLS_CERT"),
+            TLSKeyFile:  getValue("WEBHOOK_TLS_KEY"),
+        },
+        History: HistoryParameters{
+            Store: getEnvString("HISTORY_STORE", "bolt"),
+            Path:  getValue("HISTORY_PATH"),
+        },
+        Group: GroupParameters{
+            TriggerWord: getValue("GROUP_TRIGGER"),
+            Billing:     getEnvString("GROUP_BILLING", "sender"),
+        },
+        AllowedModels: map[string][]string{
+            "ADMIN": getStrList("MODELS_ADMIN"),
+            "USER":  getStrList("MODELS_USER"),
+            "GUEST": getStrList("MODELS_GUEST"),
+        },
+        ContextBudget:      getEnvInt("CONTEXT_BUDGET", 16000),
+        ContextBudgets:     getStrIntMap("CONTEXT_BUDGETS"),
+        HistoryOverflow:    getEnvString("HISTORY_OVERFLOW", "drop"),
+        LongAnswerDocument: getEnvInt("LONG_ANSWER_DOCUMENT", 0),
+        Transcription: TranscriptionParameters{
+            Enabled:        getEnvString("TRANSCRIPTION", "false") == "true",
+            BaseURL:        getEnvString("TRANSCRIPTION_BASE_URL", "https://api.openai.com/v1"),
+            APIKey:         getValue("TRANSCRIPTION_API_KEY"),
+            Model:          getEnvString("TRANSCRIPTION_MODEL", "whisper-1"),
+            Language:       getValue("TRANSCRIPTION_LANGUAGE"),
+            PricePerMinute: getEnvFloat("TRANSCRIPTION_PRICE", 0.006),
+        },
+        DocumentMaxSize:   getRoleIntMap("DOCUMENT_MAX_SIZE"),
+        DocumentMaxTokens: getRoleIntMap("DOCUMENT_MAX_TOKENS"),
+        Image: ImageParameters{
+            Model: getValue("IMAGE_MODEL"),
+            Size:  getEnvString("IMAGE_SIZE", "1024x1024"),
+            Roles: getStrList("IMAGE_ROLES"),
+            Price: getEnvFloat("IMAGE_PRICE", 0.04),
+        },
+        Retry: RetryParameters{
+            Attempts: getEnvInt("RETRY_ATTEMPTS", 3),
+            Delay:    getEnvInt("RETRY_DELAY", 500),
+            MaxDelay: getEnvInt("RETRY_MAX_DELAY", 8000),
+        },
+        FallbackModels: getStrList("FALLBACK_MODELS"),
+        RateLimit: RateLimitParameters{
+            PerUser:      getRoleIntMap("RATE_LIMIT"),
+            Burst:        getRoleIntMap("RATE_BURST"),
+            PerRole:      getRoleIntMap("ROLE_RATE_LIMIT"),
+            MaxStreams:   getEnvInt("MAX_CONCURRENT_STREAMS", 8),
+            QueueSize:    getEnvInt("STREAM_QUEUE_SIZE", 32),
+            QueueTimeout: getEnvInt("STREAM_QUEUE_TIMEOUT", 120),
+        },
+        MessagePolicy: getEnvString("MESSAGE_POLICY", "queue"),
+        ShutdownTimeout: getEnvInt("SHUTDOWN_TIMEOUT", 30),
+        EditPolicy: getEnvString("EDIT_POLICY", "latest"),
+        Personas: getPersonas(),
+        Inline: InlineParameters{
+            Enabled:   getEnvString("INLINE_MODE", "false") == "true",
+            Debounce:  getEnvInt("INLINE_DEBOUNCE", 1000),
+            CacheTTL:  getEnvInt("INLINE_CACHE_TTL", 600),
+            CacheSize: getEnvInt("INLINE_CACHE_SIZE", 500),
+        },
+        LogLevel:  getEnvString("LOG_LEVEL", "info"),
+        LogFormat: getEnvString("LOG_FORMAT", "text"),
+        Metrics: MetricsParameters{
+            Listen: getValue("METRICS_LISTEN"),
+            Path:   getEnvString("METRICS_PATH", "/metrics"),
+        },
+    }
+
+    if len(loadErrors) > 0 {
+        return nil, errors.Join(loadErrors...)
     }
 
     // Validate required configurations
@@ -163,13 +507,125 @@ func Load() (*Config, error) {
     if config.BudgetPeriod == "" {
         return nil, fmt.Errorf("BUDGET_PERIOD is required")
     }
+    if config.Model.Type != "" && config.Model.Type != "openrouter" && config.Model.Type != "openai" {
+        return nil, fmt.Errorf("unknown TYPE %q, expected openrouter or openai", config.Model.Type)
+    }
+    switch config.Transport.Mode {
+    case "polling":
+    case "webhook":
+        if config.Transport.WebhookURL == "" {
+            return nil, fmt.Errorf("WEBHOOK_URL is required when TRANSPORT is webhook")
+        }
+        if (config.Transport.TLSCertFile == "") != (config.Transport.TLSKeyFile == "") {
+            return nil, fmt.Errorf("WEBHOOK_TLS_CERT and WEBHOOK_TLS_KEY must be set together")
+        }
+    default:
+        return nil, fmt.Errorf("unknown TRANSPORT %q, expected polling or webhook", config.Transport.Mode)
+    }
+    if config.Group.Billing != "sender" && config.Group.Billing != "chat" {
+        return nil, fmt.Errorf("unknown GROUP_BILLING %q, expected sender or chat", config.Group.Billing)
+    }
+    if config.HistoryOverflow != "drop" && config.HistoryOverflow != "summarize" {
+        return nil, fmt.Errorf("unknown HISTORY_OVERFLOW %q, expected drop or summarize", config.HistoryOverflow)
+    }
+    var level slog.Level
+    if err := level.UnmarshalText([]byte(config.LogLevel)); err != nil {
+        return nil, fmt.Errorf("unknown LOG_LEVEL %q, expected debug, info, warn or error", config.LogLevel)
+    }
+    if config.LogFormat != "text" && config.LogFormat != "json" {
+        return nil, fmt.Errorf("unknown LOG_FORMAT %q, expected text or json", config.LogFormat)
+    }
+    switch config.MessagePolicy {
+    case "queue", "cancel", "reject":
+    default:
+        return nil, fmt.Errorf("unknown MESSAGE_POLICY %q, expected queue, cancel or reject", config.MessagePolicy)
+    }
+    for _, persona := range config.Personas {
+        if persona.Prompt == "" {
+            return nil, fmt.Errorf("persona %q has no prompt", persona.ID)
+        }
+        if t := persona.Temperature; t != nil && (*t < 0 || *t > 2) {
+            return nil, fmt.Errorf("persona %q temperature must be between 0 and 2", persona.ID)
+        }
+    }
+    if config.EditPolicy != "latest" && config.EditPolicy != "branch" {
+        return nil, fmt.Errorf("unknown EDIT_POLICY %q, expected latest or branch", config.EditPolicy)
+    }
+    if config.Transcription.Enabled && config.Transcription.APIKey == "" {
+        return nil, fmt.Errorf("TRANSCRIPTION_API_KEY is required when TRANSCRIPTION is true")
+    }
+    if config.ShutdownTimeout < 0 {
+        return nil, fmt.Errorf("SHUTDOWN_TIMEOUT must not be negative")
+    }
+    if config.Retry.Attempts < 1 {
+        return nil, fmt.Errorf("RETRY_ATTEMPTS must be at least 1")
+    }
+    if config.History.Path == "" {
+        switch config.History.Store {
+        case "bolt":
+            config.History.Path = "logs/history.db"
+        case "file":
+            config.History.Path = "logs/history"
+        }
+    }
 
     // Verify language configuration
     language := lang.Translate("language", config.Lang)
     if language == "" {
-        log.Printf("Warning: Language '%s' not found, defaulting to 'en'", config.Lang)
+        slog.Warn("Language not found, defaulting to en", "lang", config.Lang)
         config.Lang = "en"
     }
 
     return config, nil
-}
\ No newline at end of file
+}
+
+// ModelAllowed reports whether a user with the given role may use model. The default
+// model is always allowed, allowlist entries ending in * match model ID prefixes.
+func (c *Config) ModelAllowed(role, model string) bool {
+    if model == c.Model.ModelName {
+        return true
+    }
+    for _, pattern := range c.AllowedModels[role] {
+        if pattern == model || strings.HasSuffix(pattern, "*") && strings.HasPrefix(model, strings.TrimSuffix(pattern, "*")) {
+            return true
+        }
+    }
+    return false
+}
+
+// Persona returns the persona with the given ID.
+func (c *Config) Persona(id string) (Persona, bool) {
+    for _, persona := range c.Personas {
+        if persona.ID == id {
+            return persona, true
+        }
+    }
+    return Persona{}, false
+}
+
+// ContextBudgetFor returns the token budget for requests to model.
+func (c *Config) ContextBudgetFor(model string) int {
+    if budget, ok := c.ContextBudgets[model]; ok {
+        return budget
+    }
+    return c.ContextBudget
+}
+
+// DocumentLimits returns the maximum size in bytes and the maximum number of tokens of
+// documents uploaded by a user with the given role. Zero means documents are not allowed.
+func (c *Config) DocumentLimits(role string) (maxSize, maxTokens int) {
+    return c.DocumentMaxSize[role] * 1024, c.DocumentMaxTokens[role]
+}
+
+// ImageAllowed reports whether a user with the given role may generate images.
+func (c *Config) ImageAllowed(role string) bool {
+    if c.Image.Model == "" {
+        return false
+    }
+    for _, allowed := range c.Image.Roles {
+        if strings.EqualFold(allowed, role) {
+            return true
+        }
+    }
+    return false
+}
@@ -2,7 +2,8 @@
 package config
 
 import (
-	"log"
+	"fmt"
+	"log/slog"
 	"sync"
 
 	"github.com/fsnotify/fsnotify"
@@ -12,7 +13,15 @@ import (
 type Manager struct {
 	config    *Config
 	mutex     sync.RWMutex
-	listeners []chan<- Config
+	listeners []chan<- Reload
+}
+
+// Reload is sent to subscribers after the config file changed. If the new
+// configuration is invalid, New is nil, Err is set and Old stays in effect.
+type Reload struct {
+	Old *Config
+	New *Config
+	Err error
 }
 
 func NewManager(configPath string) (*Manager, error) {
@@ -22,19 +31,17 @@ func NewManager(configPath string) (*Manager, error) {
 	viper.AutomaticEnv()
 
 	if err := viper.ReadInConfig(); err != nil {
-		log.Fatalf("Error reading config file, %s", err)
-		return nil, err
+		return nil, fmt.Errorf("error reading config file: %w", err)
 	}
 
 	manager := &Manager{
-		listeners: make([]chan<- Config, 0),
+		listeners: make([]chan<- Reload, 0),
 	}
 
 	// Initial config load
 	config, err := Load()
 	if err != nil {
-		log.Fatalf("Failed to load configuration: %v", err)
-		return nil, err
+		return nil, fmt.Errorf("failed to load configuration: %w", err)
 	}
 	manager.config = config
 
@@ -48,9 +55,11 @@ func NewManager(configPath string) (*Manager, error) {
 }
 
 func (m *Manager) reloadConfig() {
+	oldConfig := m.GetConfig()
 	newConfig, err := Load()
 	if err != nil {
-		log.Printf("Failed to reload config: %v", err)
+		slog.Error("Failed to reload config, keeping the previous one", "error", err)
+		m.notify(Reload{Old: oldConfig, Err: err})
 		return
 	}
 
@@ -58,15 +67,23 @@ func (m *Manager) reloadConfig() {
 	m.config = newConfig
 	m.mutex.Unlock()
 
-	// Notify all listeners
+	m.notify(Reload{Old: oldConfig, New: newConfig})
+}
+
+// notify sends a reload to all listeners.
+func (m *Manager) notify(reload Reload) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
 	for _, listener := range m.listeners {
-		listener <- *newConfig
+		listener <- reload
 	}
 }
 
-func (m *Manager) Subscribe() <-chan Config {
-	ch := make(chan Config, 1)
+func (m *Manager) Subscribe() <-chan Reload {
+	ch := make(chan Reload, 1)
+	m.mutex.Lock()
 	m.listeners = append(m.listeners, ch)
+	m.mutex.Unlock()
 	return ch
 }
 
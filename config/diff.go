@@ -0,0 +1,96 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// secretFields are reported as changed without their values.
+var secretFields = map[string]bool{
+	"TelegramBotToken": true,
+	"OpenAIApiKey":     true,
+	"SecretToken":      true,
+	"APIKey":           true,
+}
+
+// Diff describes the settings that differ between two configurations, one line per setting.
+func Diff(old, new *Config) []string {
+	return diffStruct("", reflect.ValueOf(*old), reflect.ValueOf(*new))
+}
+
+func diffStruct(prefix string, old, new reflect.Value) []string {
+	var changes []string
+	for i := 0; i < old.NumField(); i++ {
+		field := old.Type().Field(i)
+		oldValue, newValue := old.Field(i), new.Field(i)
+		if reflect.DeepEqual(oldValue.Interface(), newValue.Interface()) {
+			continue
+		}
+		name := prefix + field.Name
+		switch {
+		case secretFields[field.Name]:
+			changes = append(changes, name+" changed")
+		case field.Type.Kind() == reflect.Struct && field.Type.PkgPath() == old.Type().PkgPath():
+			changes = append(changes, diffStruct(name+".", oldValue, newValue)...)
+		default:
+			changes = append(changes, fmt.Sprintf("%s: %s → %s", name, shorten(oldValue.Interface()), shorten(newValue.Interface())))
+		}
+	}
+	return changes
+}
+
+// shorten formats a value, cutting long ones such as prompts.
+func shorten(value interface{}) string {
+	text := []rune(strings.ReplaceAll(format(reflect.ValueOf(value)), "\n", " "))
+	if len(text) > 80 {
+		return string(text[:80]) + "…"
+	}
+	return string(text)
+}
+
+// format prints a value like fmt.Sprint, but with the values pointers point to instead
+// of their addresses, also inside slices, maps and structs such as Persona.Temperature.
+func format(v reflect.Value) string {
+	if v.IsValid() && v.Kind() != reflect.Pointer && v.CanInterface() {
+		if stringer, ok := v.Interface().(fmt.Stringer); ok {
+			return stringer.String()
+		}
+	}
+	switch v.Kind() {
+	case reflect.Invalid:
+		return "<nil>"
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return "<nil>"
+		}
+		return format(v.Elem())
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return "[]"
+		}
+		items := make([]string, v.Len())
+		for i := range items {
+			items[i] = format(v.Index(i))
+		}
+		return "[" + strings.Join(items, " ") + "]"
+	case reflect.Map:
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return format(keys[i]) < format(keys[j]) })
+		items := make([]string, len(keys))
+		for i, key := range keys {
+			items[i] = format(key) + ":" + format(v.MapIndex(key))
+		}
+		return "map[" + strings.Join(items, " ") + "]"
+	case reflect.Struct:
+		fields := make([]string, v.NumField())
+		for i := range fields {
+			fields[i] = format(v.Field(i))
+		}
+		return "{" + strings.Join(fields, " ") + "}"
+	default:
+		// fmt prints the value held by v, also for unexported fields
+		return fmt.Sprint(v)
+	}
+}
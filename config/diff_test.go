@@ -0,0 +1,85 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	cool, hot := 0.2, 1.5
+	tests := []struct {
+		name   string
+		change func(c *Config)
+		want   []string
+	}{
+		{
+			name:   "no change",
+			change: func(c *Config) {},
+			want:   nil,
+		},
+		{
+			name:   "plain field",
+			change: func(c *Config) { c.MaxTokens = 2000 },
+			want:   []string{"MaxTokens: 1000 → 2000"},
+		},
+		{
+			name:   "nested field",
+			change: func(c *Config) { c.Transport.Mode = "webhook" },
+			want:   []string{"Transport.Mode: polling → webhook"},
+		},
+		{
+			name: "secrets are masked",
+			change: func(c *Config) {
+				c.TelegramBotToken = "new-token"
+				c.Transport.SecretToken = "new-secret"
+			},
+			want: []string{"TelegramBotToken changed", "Transport.SecretToken changed"},
+		},
+		{
+			name:   "slice",
+			change: func(c *Config) { c.AdminChatIDs = []int64{1, 2} },
+			want:   []string{"AdminChatIDs: [1] → [1 2]"},
+		},
+		{
+			name:   "pointer in a slice",
+			change: func(c *Config) { c.Personas[0].Temperature = &hot },
+			want:   []string{"Personas: [{coder Coder  mini 0.2 }] → [{coder Coder  mini 1.5 }]"},
+		},
+		{
+			name:   "nil pointer",
+			change: func(c *Config) { c.Personas[0].Temperature = nil },
+			want:   []string{"Personas: [{coder Coder  mini 0.2 }] → [{coder Coder  mini <nil> }]"},
+		},
+		{
+			name:   "map",
+			change: func(c *Config) { c.ContextBudgets = map[string]int{"b": 2, "a": 1} },
+			want:   []string{"ContextBudgets: map[] → map[a:1 b:2]"},
+		},
+		{
+			name:   "long value is shortened",
+			change: func(c *Config) { c.SystemPrompt = "line\n" + strings.Repeat("a", 100) },
+			want:   []string{"SystemPrompt: You are a helpful assistant. → line " + strings.Repeat("a", 75) + "…"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			old := &Config{
+				TelegramBotToken: "token",
+				MaxTokens:        1000,
+				SystemPrompt:     "You are a helpful assistant.",
+				AdminChatIDs:     []int64{1},
+				Transport:        TransportParameters{Mode: "polling", SecretToken: "secret"},
+				Personas:         []Persona{{ID: "coder", Name: "Coder", Model: "mini", Temperature: &cool}},
+			}
+			updated := *old
+			updated.AdminChatIDs = append([]int64(nil), old.AdminChatIDs...)
+			updated.Personas = append([]Persona(nil), old.Personas...)
+			tt.change(&updated)
+
+			if got := Diff(old, &updated); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Diff() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
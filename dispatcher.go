@@ -0,0 +1,404 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"html"
+	"openrouter-gpt-telegram-bot/api"
+	"openrouter-gpt-telegram-bot/config"
+	"openrouter-gpt-telegram-bot/document"
+	"openrouter-gpt-telegram-bot/lang"
+	"openrouter-gpt-telegram-bot/logging"
+	"openrouter-gpt-telegram-bot/metrics"
+	"openrouter-gpt-telegram-bot/provider"
+	"openrouter-gpt-telegram-bot/ratelimit"
+	"openrouter-gpt-telegram-bot/transport"
+	"openrouter-gpt-telegram-bot/user"
+	"strconv"
+	"sync"
+)
+
+// Dispatcher routes Telegram updates to command and chat handlers.
+// It does not know which transport the updates come from.
+type Dispatcher struct {
+	bot         *tgbotapi.BotAPI
+	configs     *config.Manager
+	userManager *user.Manager
+	// chatProvider and catalog are replaced when the provider settings are reloaded
+	chatProvider provider.Provider
+	catalog      *modelCatalog
+	mu           sync.RWMutex
+	limiter      *ratelimit.Limiter
+	streams      *ratelimit.Pool
+	turns        *turnQueue
+	inline       *inlineState
+	// base is the parent of all update contexts, stop cancels it on shutdown
+	base context.Context
+	stop context.CancelCauseFunc
+	// inflight counts the work still running outside of HandleUpdate
+	inflight sync.WaitGroup
+}
+
+func NewDispatcher(bot *tgbotapi.BotAPI, p provider.Provider, configs *config.Manager, userManager *user.Manager) *Dispatcher {
+	d := &Dispatcher{
+		bot:          bot,
+		configs:      configs,
+		userManager:  userManager,
+		chatProvider: p,
+		catalog:      newModelCatalog(p),
+		limiter:      ratelimit.NewLimiter(),
+		streams:      ratelimit.NewPool(),
+		inline:       newInlineState(),
+	}
+	d.base, d.stop = context.WithCancelCause(context.Background())
+	d.turns = newTurnQueue(&d.inflight)
+	return d
+}
+
+// conf returns the configuration currently in effect.
+func (d *Dispatcher) conf() *config.Config {
+	return d.configs.GetConfig()
+}
+
+func (d *Dispatcher) provider() provider.Provider {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.chatProvider
+}
+
+func (d *Dispatcher) modelCatalog() *modelCatalog {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.catalog
+}
+
+// trackers returns the tracker of the sender, the tracker holding the conversation history
+// and the tracker that is charged for answers. In private chats all three are the sender,
+// in groups the conversation is shared by the chat (or forum topic).
+func (d *Dispatcher) trackers(from *tgbotapi.User, chat *tgbotapi.Chat, threadID int) (sender, conversation, payer *user.UsageTracker) {
+	sender = d.userManager.GetUser(from.ID, from.UserName, d.conf())
+	if !isGroup(chat) {
+		return sender, sender, sender
+	}
+	conversation = d.userManager.GetConversation(chat.ID, threadID, chat.Title, d.conf())
+	payer = sender
+	if d.conf().Group.Billing == "chat" {
+		payer = d.userManager.GetUser(chat.ID, chat.Title, d.conf())
+	}
+	return sender, conversation, payer
+}
+
+// Run handles updates until the channel is closed or ctx is canceled.
+func (d *Dispatcher) Run(ctx context.Context, updates <-chan transport.Update) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case update, ok := <-updates:
+			if !ok {
+				return
+			}
+			d.HandleUpdate(update)
+		}
+	}
+}
+
+func (d *Dispatcher) HandleUpdate(update transport.Update) {
+	metrics.UpdatesReceived.WithLabelValues(updateType(update)).Inc()
+	ctx := updateContext(d.base, update)
+	if update.CallbackQuery != nil {
+		d.handleCallback(ctx, update)
+		return
+	}
+	if update.EditedMessage != nil {
+		d.handleEdit(ctx, update)
+		return
+	}
+	if update.InlineQuery != nil {
+		d.handleInlineQuery(ctx, update.InlineQuery)
+		return
+	}
+	if update.Message == nil || update.Message.From == nil {
+		return
+	}
+	bot := d.bot
+	conf := d.conf()
+	message := update.Message
+	group := isGroup(message.Chat)
+
+	userStats, conversation, payer := d.trackers(message.From, message.Chat, update.MessageThreadID)
+
+	if message.IsCommand() {
+		if group && d.commandForOtherBot(message) {
+			return
+		}
+		metrics.CommandsExecuted.WithLabelValues(commandLabel(message.Command())).Inc()
+		if adminCommands[message.Command()] {
+			d.handleAdminCommand(ctx, message, userStats)
+			return
+		}
+		switch message.Command() {
+		case "start":
+			msgText := lang.Translate("commands.start", conf.Lang) + lang.Translate("commands.help", conf.Lang) + lang.Translate("commands.start_end", conf.Lang)
+			msg := newReply(message, msgText)
+			msg.ParseMode = "HTML"
+			bot.Send(msg)
+		case "help":
+			helpText := lang.Translate("commands.help", conf.Lang)
+			if userStats.GetUserRole(conf) == "ADMIN" {
+				helpText += "\n\n" + lang.Translate("admin.help", conf.Lang)
+			}
+			msg := newReply(message, helpText)
+			msg.ParseMode = "HTML"
+			bot.Send(msg)
+		case "reset":
+			args := message.CommandArguments()
+			msg := newReply(message, "")
+
+			if args == "system" {
+				conversation.ResetSystemPrompt()
+				msg.Text = lang.Translate("commands.reset_system", conf.Lang)
+			} else if args != "" {
+				conversation.SetSystemPrompt(args)
+				msg.Text = lang.Translate("commands.reset_prompt", conf.Lang) + args + "."
+			} else {
+				conversation.ClearHistory()
+				msg.Text = lang.Translate("commands.reset", conf.Lang)
+			}
+			bot.Send(msg)
+		case "stats":
+			conversation.CheckHistory(conf.MaxHistorySize, conf.MaxHistoryTime)
+			countedUsage := strconv.FormatFloat(payer.GetCurrentCost(conf.BudgetPeriod), 'f', 6, 64)
+			todayUsage := strconv.FormatFloat(payer.GetCurrentCost("daily"), 'f', 6, 64)
+			monthUsage := strconv.FormatFloat(payer.GetCurrentCost("monthly"), 'f', 6, 64)
+			totalUsage := strconv.FormatFloat(payer.GetCurrentCost("total"), 'f', 6, 64)
+			messagesCount := strconv.Itoa(len(conversation.GetMessages()))
+
+			var statsMessage string
+			if userStats.CanViewStats(conf) {
+				statsMessage = fmt.Sprintf(
+					lang.Translate("commands.stats", conf.Lang),
+					countedUsage, todayUsage, monthUsage, totalUsage, messagesCount)
+			} else {
+				statsMessage = fmt.Sprintf(
+					lang.Translate("commands.stats_min", conf.Lang), messagesCount)
+			}
+			if persona, ok := conversation.Persona(conf); ok {
+				statsMessage += "\n" + fmt.Sprintf(lang.Translate("persona.stats", conf.Lang), html.EscapeString(persona.Name))
+			}
+
+			msg := newReply(message, statsMessage)
+			msg.ParseMode = "HTML"
+			bot.Send(msg)
+
+		case "model":
+			d.handleModelCommand(ctx, message, userStats, conversation)
+		case "history":
+			d.handleHistoryCommand(ctx, message, conversation)
+		case "persona":
+			d.handlePersonaCommand(ctx, message, conversation)
+		case "image":
+			if !d.allowRequest(ctx, message, userStats) {
+				return
+			}
+			d.inflight.Add(1)
+			go func() {
+				defer d.inflight.Done()
+				d.handleImageCommand(ctx, message, userStats, payer)
+			}()
+		case "stop":
+			if conversation.StopGeneration(api.ErrStopped) {
+				msg := newReply(message, lang.Translate("commands.stop", conf.Lang))
+				bot.Send(msg)
+			} else {
+				msg := newReply(message, lang.Translate("commands.stop_err", conf.Lang))
+				bot.Send(msg)
+			}
+		}
+		return
+	}
+
+	if group {
+		text, ok := d.addressedToBot(message)
+		if !ok {
+			return
+		}
+		if message.Text != "" || text != "" {
+			message.Text = attributeSpeaker(message.From, text)
+		}
+	}
+
+	if api.IsAudio(message) && !conf.Transcription.Enabled {
+		return
+	}
+	if !d.allowRequest(ctx, message, userStats) {
+		return
+	}
+	d.submitTurn(ctx, message, conversation, func(ctx context.Context) {
+		// Replying to an earlier answer continues the conversation from there
+		if answerID := d.repliedAnswer(message); answerID != 0 && conversation.ForkAt(answerID) {
+			logging.From(ctx).Info("Forked conversation", "reply_to", answerID)
+		}
+		switch {
+		case message.Document != nil:
+			d.handleDocument(ctx, message, userStats, conversation, payer)
+		case api.IsAudio(message):
+			d.answerAudio(ctx, message, userStats, conversation, payer)
+		default:
+			d.answer(ctx, message, userStats, conversation, payer)
+		}
+	})
+}
+
+// answerAudio transcribes a voice or audio message, shows the transcript and answers it
+// like a text message. The transcription is charged to the payer.
+func (d *Dispatcher) answerAudio(ctx context.Context, message *tgbotapi.Message, sender, conversation, payer *user.UsageTracker) {
+	conf := d.conf()
+	if !hasAccess(conf, sender, payer) {
+		d.bot.Send(newReply(message, lang.Translate("budget_out", conf.Lang)))
+		return
+	}
+
+	transcript, cost, err := api.Transcribe(ctx, d.bot, conf, message)
+	if err != nil {
+		logging.From(ctx).Error("Failed to transcribe audio", "error", err)
+		d.bot.Send(newReply(message, lang.Translate("voice.failed", conf.Lang)))
+		return
+	}
+	payer.AddCost(cost)
+	if transcript == "" {
+		d.bot.Send(newReply(message, lang.Translate("voice.empty", conf.Lang)))
+		return
+	}
+
+	msg := tgbotapi.NewMessage(message.Chat.ID, "<blockquote>"+html.EscapeString(transcript)+"</blockquote>")
+	msg.ReplyToMessageID = message.MessageID
+	msg.ParseMode = tgbotapi.ModeHTML
+	if _, err := d.bot.Send(msg); err != nil {
+		logging.From(ctx).Error("Failed to send transcript", "error", err)
+	}
+
+	message.Text = transcript
+	if isGroup(message.Chat) {
+		message.Text = attributeSpeaker(message.From, transcript)
+	}
+	d.answer(ctx, message, sender, conversation, payer)
+}
+
+// updateContext returns a child of parent whose logger carries a new request ID and
+// the user and chat of the update.
+func updateContext(parent context.Context, update transport.Update) context.Context {
+	attrs := []any{"update_id", update.UpdateID}
+	if from := update.SentFrom(); from != nil {
+		attrs = append(attrs, "user_id", from.ID)
+	}
+	if chat := update.FromChat(); chat != nil {
+		attrs = append(attrs, "chat_id", chat.ID)
+	}
+	return logging.NewRequest(parent, attrs...)
+}
+
+// updateType names the kind of an update for metrics.
+func updateType(update transport.Update) string {
+	message := update.Message
+	switch {
+	case update.CallbackQuery != nil:
+		return "callback_query"
+	case update.EditedMessage != nil:
+		return "edited_message"
+	case update.InlineQuery != nil:
+		return "inline_query"
+	case message == nil:
+		return "other"
+	case message.IsCommand():
+		return "command"
+	case message.Document != nil:
+		return "document"
+	case message.Voice != nil || message.Audio != nil:
+		return "audio"
+	case len(message.Photo) > 0:
+		return "photo"
+	case message.Text != "":
+		return "text"
+	default:
+		return "other"
+	}
+}
+
+// knownCommands keeps the command label of the metrics bounded.
+var knownCommands = map[string]bool{
+	"start": true, "help": true, "reset": true, "stats": true, "model": true, "stop": true, "image": true, "history": true, "persona": true,
+}
+
+func commandLabel(command string) string {
+	if knownCommands[command] || adminCommands[command] {
+		return command
+	}
+	return "unknown"
+}
+
+// answer streams the model's answer to message and charges the payer for it. The model
+// picked for the conversation is only used if the sender's role allows it. It reports
+// whether the turn was added to the history.
+func (d *Dispatcher) answer(ctx context.Context, message *tgbotapi.Message, sender, conversation, payer *user.UsageTracker) bool {
+	return d.answerInto(ctx, message, sender, conversation, payer, message.MessageID, nil)
+}
+
+// answerInto is answer showing the answer in the messages of an earlier answer and saving
+// the question with questionID, 0 for prompts the user did not write.
+func (d *Dispatcher) answerInto(ctx context.Context, message *tgbotapi.Message, sender, conversation, payer *user.UsageTracker, questionID int, answerIDs []int) bool {
+	conf := d.conf()
+	if !d.haveBudget(ctx, message, sender, payer) {
+		return false
+	}
+	if !d.acquireStream(ctx, message) {
+		return false
+	}
+	defer d.releaseStream()
+
+	conversation.CheckHistory(conf.MaxHistorySize, conf.MaxHistoryTime)
+	if docs := conversation.TakeDocuments(); len(docs) > 0 {
+		message.Text = document.Prompt(docs, message.Text)
+	}
+	p := d.provider()
+	role := sender.GetUserRole(conf)
+	model := conversation.ModelFor(conf, role)
+	if summaryID := api.FitHistory(ctx, p, conf, conversation, model, message.Text); summaryID != "" {
+		payer.AddGenerationCost(ctx, p, summaryID)
+	}
+	responseID, answered := api.HandleChatGPTStreamResponse(ctx, d.bot, p, message, conf, conversation, role, model, questionID, answerIDs)
+	if responseID != "" {
+		payer.AddGenerationCost(ctx, p, responseID)
+	}
+	return answered
+}
+
+// haveBudget reports whether the request may be charged to the payer, telling the sender if not.
+func (d *Dispatcher) haveBudget(ctx context.Context, message *tgbotapi.Message, sender, payer *user.UsageTracker) bool {
+	conf := d.conf()
+	if hasAccess(conf, sender, payer) {
+		return true
+	}
+	if _, err := d.bot.Send(newReply(message, lang.Translate("budget_out", conf.Lang))); err != nil {
+		logging.From(ctx).Error("Failed to send message", "error", err)
+	}
+	return false
+}
+
+// hasAccess reports whether the payer has budget left and the sender may use the bot.
+// When the chat pays, the role and the budget of the sender still decide whether they
+// may use the bot, but the sender is not charged.
+func hasAccess(conf *config.Config, sender, payer *user.UsageTracker) bool {
+	return payer.HaveAccess(conf) && (sender == payer || sender.HaveAccess(conf))
+}
+
+// newReply creates a message to the chat of message. In groups it replies to message,
+// which keeps the answer in the right forum topic and shows who it is meant for.
+func newReply(message *tgbotapi.Message, text string) tgbotapi.MessageConfig {
+	msg := tgbotapi.NewMessage(message.Chat.ID, text)
+	if isGroup(message.Chat) {
+		msg.ReplyToMessageID = message.MessageID
+	}
+	return msg
+}
@@ -0,0 +1,88 @@
+// Package document extracts the text of uploaded files so it can be added to a conversation.
+package document
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/ledongthuc/pdf"
+	"io"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// ErrUnsupported is returned for files that are neither text nor PDF.
+var ErrUnsupported = errors.New("unsupported document type")
+
+// ErrNoText is returned for documents without extractable text, such as scanned PDFs.
+var ErrNoText = errors.New("document contains no text")
+
+// Document is an uploaded file reduced to its text.
+type Document struct {
+	Name string `json:"name"`
+	Text string `json:"text"`
+}
+
+// Extract returns the text of a plain text, source code, CSV, Markdown or PDF file.
+func Extract(name, mimeType string, data []byte) (Document, error) {
+	var text string
+	var err error
+	if strings.EqualFold(filepath.Ext(name), ".pdf") || mimeType == "application/pdf" {
+		text, err = extractPDF(data)
+	} else {
+		text, err = extractText(data)
+	}
+	if err != nil {
+		return Document{}, err
+	}
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return Document{}, ErrNoText
+	}
+	return Document{Name: name, Text: text}, nil
+}
+
+// extractText accepts any UTF-8 file without NUL bytes, which covers text, code, CSV and
+// Markdown regardless of the extension or the MIME type Telegram guessed.
+func extractText(data []byte) (string, error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	if !utf8.Valid(data) || bytes.IndexByte(data, 0) != -1 {
+		return "", ErrUnsupported
+	}
+	return strings.ReplaceAll(string(data), "\r\n", "\n"), nil
+}
+
+func extractPDF(data []byte) (text string, err error) {
+	// The PDF reader panics on some malformed files
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("reading PDF: %v", r)
+		}
+	}()
+
+	reader, err := pdf.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", fmt.Errorf("reading PDF: %w", err)
+	}
+	plain, err := reader.GetPlainText()
+	if err != nil {
+		return "", fmt.Errorf("extracting PDF text: %w", err)
+	}
+	content, err := io.ReadAll(plain)
+	if err != nil {
+		return "", fmt.Errorf("extracting PDF text: %w", err)
+	}
+	return string(content), nil
+}
+
+// Prompt combines documents and the user's text into one user turn. Each document is
+// wrapped in a tag with its file name so the model can tell them apart.
+func Prompt(docs []Document, text string) string {
+	var prompt strings.Builder
+	for _, doc := range docs {
+		fmt.Fprintf(&prompt, "<file name=%q>\n%s\n</file>\n\n", doc.Name, doc.Text)
+	}
+	prompt.WriteString(text)
+	return prompt.String()
+}
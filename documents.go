@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/sashabaranov/go-openai"
+	"html"
+	"openrouter-gpt-telegram-bot/api"
+	"openrouter-gpt-telegram-bot/document"
+	"openrouter-gpt-telegram-bot/lang"
+	"openrouter-gpt-telegram-bot/logging"
+	"openrouter-gpt-telegram-bot/tokenizer"
+	"openrouter-gpt-telegram-bot/user"
+)
+
+// handleDocument extracts the text of an uploaded document and attaches it to the next
+// user turn of the conversation. A caption is answered right away as that turn.
+func (d *Dispatcher) handleDocument(ctx context.Context, message *tgbotapi.Message, sender, conversation, payer *user.UsageTracker) {
+	conf := d.conf()
+	file := message.Document
+	name := html.EscapeString(file.FileName)
+
+	maxSize, maxTokens := conf.DocumentLimits(sender.GetUserRole(conf))
+	if maxSize == 0 || maxTokens == 0 {
+		d.sendHTML(ctx, message, lang.Translate("document.denied", conf.Lang))
+		return
+	}
+	if file.FileSize > maxSize {
+		d.sendHTML(ctx, message, fmt.Sprintf(lang.Translate("document.too_large", conf.Lang), name, maxSize/1024))
+		return
+	}
+
+	data, _, err := api.DownloadFile(ctx, d.bot, file.FileID)
+	if err != nil {
+		logging.From(ctx).Error("Failed to download document", "error", err)
+		d.sendHTML(ctx, message, lang.Translate("document.failed", conf.Lang))
+		return
+	}
+	doc, err := document.Extract(file.FileName, file.MimeType, data)
+	switch {
+	case errors.Is(err, document.ErrUnsupported):
+		d.sendHTML(ctx, message, fmt.Sprintf(lang.Translate("document.unsupported", conf.Lang), name))
+		return
+	case errors.Is(err, document.ErrNoText):
+		d.sendHTML(ctx, message, fmt.Sprintf(lang.Translate("document.empty", conf.Lang), name))
+		return
+	case err != nil:
+		logging.From(ctx).Error("Failed to extract document text", "file_name", file.FileName, "error", err)
+		d.sendHTML(ctx, message, lang.Translate("document.failed", conf.Lang))
+		return
+	}
+
+	// The limit covers all documents waiting for the same turn
+	model := conversation.ModelFor(conf, sender.GetUserRole(conf))
+	tokens := tokenizer.Count(model, doc.Text)
+	for _, pending := range conversation.PendingDocuments() {
+		tokens += tokenizer.Count(model, pending.Text)
+	}
+	// The documents also have to fit the context of the model next to the prompt and the answer
+	room := conf.ContextBudgetFor(model) - conf.MaxTokens - tokenizer.CountMessages(model, []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleSystem, Content: conversation.PromptFor(conf)},
+		{Role: openai.ChatMessageRoleUser, Content: message.Caption},
+	})
+	maxTokens = min(maxTokens, max(room, 0))
+	if tokens > maxTokens {
+		d.sendHTML(ctx, message, fmt.Sprintf(lang.Translate("document.too_long", conf.Lang), name, tokens, maxTokens))
+		return
+	}
+	conversation.AttachDocument(doc)
+
+	if message.Text == "" {
+		message.Text = message.Caption
+	}
+	if message.Text == "" {
+		d.sendHTML(ctx, message, fmt.Sprintf(lang.Translate("document.attached", conf.Lang), name))
+		return
+	}
+	d.answer(ctx, message, sender, conversation, payer)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"openrouter-gpt-telegram-bot/api"
+	"openrouter-gpt-telegram-bot/logging"
+	"openrouter-gpt-telegram-bot/transport"
+)
+
+// handleEdit answers an edited question again, showing the new answer in the messages
+// of the old one. Edits of the latest question replace its turn. Edits of older questions
+// are ignored, or with the branch policy replace their turn and drop the turns after it.
+// The history only changes if the edited question is answered.
+func (d *Dispatcher) handleEdit(ctx context.Context, update transport.Update) {
+	message := update.EditedMessage
+	if message.From == nil || message.IsCommand() || message.Document != nil || api.IsAudio(message) {
+		return
+	}
+	conf := d.conf()
+	sender, conversation, payer := d.trackers(message.From, message.Chat, update.MessageThreadID)
+
+	found, latest := conversation.FindQuestion(message.MessageID)
+	if !found {
+		return
+	}
+	branch := conf.EditPolicy == "branch"
+	if !latest && !branch {
+		logging.From(ctx).Debug("Ignoring edit of an older question")
+		return
+	}
+
+	if isGroup(message.Chat) {
+		text, ok := d.addressedToBot(message)
+		if !ok {
+			return
+		}
+		if message.Text != "" || text != "" {
+			message.Text = attributeSpeaker(message.From, text)
+		}
+	}
+	if !d.allowRequest(ctx, message, sender) {
+		return
+	}
+	d.submitTurn(ctx, message, conversation, func(ctx context.Context) {
+		// Turns queued before the edit may have changed the history, and the old
+		// turn is put back unless the edited question is answered
+		snapshot := conversation.Snapshot()
+		answerIDs, ok := conversation.ReplaceTurn(message.MessageID, !branch)
+		if !ok {
+			logging.From(ctx).Debug("Edited question is no longer in the history")
+			return
+		}
+		logging.From(ctx).Info("Answering edited question", "branch", !latest)
+		if !d.answerInto(ctx, message, sender, conversation, payer, message.MessageID, answerIDs) {
+			logging.From(ctx).Info("Edited question was not answered, keeping the old turn")
+			conversation.Restore(snapshot)
+		}
+	})
+}
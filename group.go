@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+func isGroup(chat *tgbotapi.Chat) bool {
+	return chat.IsGroup() || chat.IsSuperGroup()
+}
+
+// commandForOtherBot reports whether a command is addressed to another bot, as in /help@otherbot.
+func (d *Dispatcher) commandForOtherBot(message *tgbotapi.Message) bool {
+	command := message.CommandWithAt()
+	at := strings.Index(command, "@")
+	return at != -1 && !strings.EqualFold(command[at+1:], d.bot.Self.UserName)
+}
+
+// addressedToBot reports whether a group message is meant for the bot: it mentions the bot,
+// replies to one of its messages or starts with the trigger word. The returned text has the
+// mention or trigger word removed.
+func (d *Dispatcher) addressedToBot(message *tgbotapi.Message) (string, bool) {
+	text := message.Text
+	if text == "" {
+		text = message.Caption
+	}
+
+	mention := regexp.MustCompile(`(?i)@` + regexp.QuoteMeta(d.bot.Self.UserName) + `\b`)
+	if mention.MatchString(text) {
+		return strings.TrimSpace(mention.ReplaceAllString(text, "")), true
+	}
+
+	if reply := message.ReplyToMessage; reply != nil && reply.From != nil && reply.From.ID == d.bot.Self.ID {
+		return text, true
+	}
+
+	if trigger := d.conf().Group.TriggerWord; trigger != "" && len(text) >= len(trigger) &&
+		strings.EqualFold(text[:len(trigger)], trigger) {
+		rest := text[len(trigger):]
+		// The trigger must be a whole word, "bot" should not match "bottle"
+		if rest == "" || !unicode.IsLetter([]rune(rest)[0]) && !unicode.IsDigit([]rune(rest)[0]) {
+			return strings.TrimLeft(rest, " ,:;!.\n"), true
+		}
+	}
+
+	return "", false
+}
+
+// speakerName returns the name a group member is attributed with in the shared history.
+func speakerName(from *tgbotapi.User) string {
+	if from == nil {
+		return "Unknown"
+	}
+	name := strings.TrimSpace(from.FirstName + " " + from.LastName)
+	if name == "" {
+		name = from.UserName
+	}
+	return name
+}
+
+// attributeSpeaker prefixes group message text with the sender's name so the model
+// can tell the participants of a shared conversation apart.
+func attributeSpeaker(from *tgbotapi.User, text string) string {
+	return fmt.Sprintf("%s: %s", speakerName(from), text)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"html"
+	"openrouter-gpt-telegram-bot/lang"
+	"openrouter-gpt-telegram-bot/user"
+	"strings"
+)
+
+const (
+	// historyShown is how many of the latest messages /history lists.
+	historyShown = 20
+	// historyPreview is the length in runes messages are cut to in /history.
+	historyPreview = 120
+)
+
+// repliedAnswer returns the ID of the bot message message replies to, or zero.
+func (d *Dispatcher) repliedAnswer(message *tgbotapi.Message) int {
+	reply := message.ReplyToMessage
+	if reply == nil || reply.From == nil || reply.From.ID != d.bot.Self.ID {
+		return 0
+	}
+	return reply.MessageID
+}
+
+// handleHistoryCommand lists the latest messages of the active branch of the conversation.
+func (d *Dispatcher) handleHistoryCommand(ctx context.Context, message *tgbotapi.Message, conversation *user.UsageTracker) {
+	conf := d.conf()
+	conversation.CheckHistory(conf.MaxHistorySize, conf.MaxHistoryTime)
+	messages := conversation.GetMessages()
+	if len(messages) == 0 {
+		d.sendHTML(ctx, message, lang.Translate("history.empty", conf.Lang))
+		return
+	}
+
+	var text strings.Builder
+	text.WriteString(fmt.Sprintf(lang.Translate("history.title", conf.Lang), len(messages), conversation.BranchCount()))
+	text.WriteString("\n")
+	if len(messages) > historyShown {
+		text.WriteString("\n" + fmt.Sprintf(lang.Translate("history.earlier", conf.Lang), len(messages)-historyShown))
+		messages = messages[len(messages)-historyShown:]
+	}
+	for _, msg := range messages {
+		icon := "📝"
+		switch msg.Role {
+		case "user":
+			icon = "👤"
+		case "assistant":
+			icon = "🤖"
+		}
+		text.WriteString("\n" + icon + " " + html.EscapeString(preview(msg.Content)))
+	}
+	d.sendHTML(ctx, message, text.String())
+}
+
+// preview returns text on one line, cut to historyPreview runes.
+func preview(text string) string {
+	runes := []rune(strings.Join(strings.Fields(text), " "))
+	if len(runes) > historyPreview {
+		return string(runes[:historyPreview]) + "…"
+	}
+	return string(runes)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"openrouter-gpt-telegram-bot/lang"
+	"openrouter-gpt-telegram-bot/logging"
+	"openrouter-gpt-telegram-bot/user"
+	"time"
+)
+
+// imageTimeout is how long an image may take to generate.
+const imageTimeout = 3 * time.Minute
+
+// handleImageCommand generates an image for the /image prompt and charges the payer for it.
+func (d *Dispatcher) handleImageCommand(ctx context.Context, message *tgbotapi.Message, sender, payer *user.UsageTracker) {
+	conf := d.conf()
+	if !conf.ImageAllowed(sender.GetUserRole(conf)) {
+		d.sendHTML(ctx, message, lang.Translate("image.denied", conf.Lang))
+		return
+	}
+	prompt := message.CommandArguments()
+	if prompt == "" {
+		d.sendHTML(ctx, message, lang.Translate("image.usage", conf.Lang))
+		return
+	}
+	if !hasAccess(conf, sender, payer) {
+		d.sendHTML(ctx, message, lang.Translate("budget_out", conf.Lang))
+		return
+	}
+
+	d.bot.Request(tgbotapi.NewChatAction(message.Chat.ID, tgbotapi.ChatUploadPhoto))
+	p := d.provider()
+	ctx, cancel := context.WithTimeout(ctx, imageTimeout)
+	defer cancel()
+	result, err := p.GenerateImage(ctx, conf.Image.Model, prompt, conf.Image.Size)
+	if err != nil {
+		logging.From(ctx).Error("Failed to generate image", "model", conf.Image.Model, "error", err)
+		d.sendHTML(ctx, message, lang.Translate("image.failed", conf.Lang))
+		return
+	}
+
+	if result.GenerationID != "" {
+		payer.AddGenerationCost(ctx, p, result.GenerationID)
+	} else {
+		payer.AddCost(conf.Image.Price * float64(len(result.Images)))
+	}
+
+	for _, image := range result.Images {
+		var file tgbotapi.RequestFileData = tgbotapi.FileURL(image.URL)
+		if image.Data != nil {
+			file = tgbotapi.FileBytes{Name: "image.png", Bytes: image.Data}
+		}
+		photo := tgbotapi.NewPhoto(message.Chat.ID, file)
+		photo.ReplyToMessageID = message.MessageID
+		if _, err := d.bot.Send(photo); err != nil {
+			logging.From(ctx).Error("Failed to send image", "error", err)
+		}
+	}
+}
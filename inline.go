@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"html"
+	"openrouter-gpt-telegram-bot/api"
+	"openrouter-gpt-telegram-bot/lang"
+	"openrouter-gpt-telegram-bot/logging"
+	"openrouter-gpt-telegram-bot/render"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// inlineTimeout bounds the generation of an inline answer. Telegram stops accepting
+	// the answer to a query after a short while, late answers are still cached.
+	inlineTimeout = time.Minute
+	// inlineAnswerLength is the length in runes inline answers are cut to, leaving room
+	// for the question and markup within the message length limit.
+	inlineAnswerLength = 3000
+)
+
+// inlineState holds the latest query of each user, so partial queries that are typed
+// over are not answered, and the cache of inline answers.
+type inlineState struct {
+	mu     sync.Mutex
+	latest map[int64]string
+	cache  map[string]inlineAnswer
+	// order is the insertion order of cache keys, the oldest are evicted first
+	order []string
+}
+
+type inlineAnswer struct {
+	text    string
+	expires time.Time
+}
+
+func newInlineState() *inlineState {
+	return &inlineState{
+		latest: make(map[int64]string),
+		cache:  make(map[string]inlineAnswer),
+	}
+}
+
+// track records queryID as the latest query of a user.
+func (s *inlineState) track(userID int64, queryID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latest[userID] = queryID
+}
+
+// isLatest reports whether queryID is still the latest query of a user.
+func (s *inlineState) isLatest(userID int64, queryID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.latest[userID] == queryID
+}
+
+// done forgets the query of a user unless a newer one arrived.
+func (s *inlineState) done(userID int64, queryID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.latest[userID] == queryID {
+		delete(s.latest, userID)
+	}
+}
+
+func (s *inlineState) get(key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	answer, ok := s.cache[key]
+	if !ok || time.Now().After(answer.expires) {
+		return "", false
+	}
+	return answer.text, true
+}
+
+func (s *inlineState) put(key, text string, ttl time.Duration, size int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.cache[key]; !ok {
+		s.order = append(s.order, key)
+	}
+	s.cache[key] = inlineAnswer{text: text, expires: time.Now().Add(ttl)}
+	for len(s.order) > size {
+		delete(s.cache, s.order[0])
+		s.order = s.order[1:]
+	}
+}
+
+// inlineCacheKey identifies a question asked with a model, system prompt and temperature,
+// ignoring case and spacing. The prompt can be private to a user, so it is part of the key.
+func inlineCacheKey(model, prompt string, temperature float64, question string) string {
+	promptSum := sha256.Sum256([]byte(prompt))
+	return fmt.Sprintf("%s\x00%x\x00%g\x00%s", model, promptSum[:16], temperature,
+		strings.ToLower(strings.Join(strings.Fields(question), " ")))
+}
+
+// handleInlineQuery answers "@bot question" typed in any chat. Queries are answered once
+// they stay unchanged for the debounce delay, with the budget and rate limits of the sender.
+// Answers are generated without history and cached per model, prompt and question.
+func (d *Dispatcher) handleInlineQuery(ctx context.Context, query *tgbotapi.InlineQuery) {
+	conf := d.conf()
+	if !conf.Inline.Enabled || query.From == nil {
+		return
+	}
+	question := strings.TrimSpace(query.Query)
+	if question == "" {
+		return
+	}
+
+	d.inline.track(query.From.ID, query.ID)
+	d.inflight.Add(1)
+	go func() {
+		defer d.inflight.Done()
+		defer d.inline.done(query.From.ID, query.ID)
+
+		select {
+		case <-time.After(time.Duration(conf.Inline.Debounce) * time.Millisecond):
+		case <-ctx.Done():
+			return
+		}
+		if !d.inline.isLatest(query.From.ID, query.ID) {
+			// The user kept typing, the newer query is answered instead
+			return
+		}
+		d.answerInline(ctx, query, question)
+	}()
+}
+
+func (d *Dispatcher) answerInline(ctx context.Context, query *tgbotapi.InlineQuery, question string) {
+	conf := d.conf()
+	logger := logging.From(ctx)
+	sender := d.userManager.GetUser(query.From.ID, query.From.UserName, conf)
+	role := sender.GetUserRole(conf)
+	model := sender.ModelFor(conf, role)
+	prompt := sender.PromptFor(conf)
+	temperature := sender.TemperatureFor(conf)
+	key := inlineCacheKey(model, prompt, temperature, question)
+
+	if !sender.HaveAccess(conf) {
+		d.sendInlineNotice(ctx, query, lang.Translate("inline.budget_out", conf.Lang))
+		return
+	}
+	// Cached answers cost nothing, so they are not rate limited
+	if answer, ok := d.inline.get(key); ok {
+		logger.Debug("Answering inline query from cache")
+		d.sendInlineAnswer(ctx, query, question, answer)
+		return
+	}
+	if ok, _ := d.checkRate(ctx, sender); !ok {
+		d.sendInlineNotice(ctx, query, lang.Translate("inline.slow_down", conf.Lang))
+		return
+	}
+
+	genCtx, cancel := context.WithTimeout(ctx, inlineTimeout)
+	defer cancel()
+	if err := d.streams.Acquire(genCtx, conf.RateLimit.MaxStreams, conf.RateLimit.QueueSize); err != nil {
+		logger.Warn("No free slot for inline answer", "error", err)
+		d.sendInlineNotice(ctx, query, lang.Translate("inline.busy", conf.Lang))
+		return
+	}
+	p := d.provider()
+	answer, responseID, err := api.Complete(genCtx, p, conf, role, model, prompt, temperature, question)
+	d.releaseStream()
+	if responseID != "" {
+		sender.AddGenerationCost(ctx, p, responseID)
+	}
+	if err != nil {
+		logger.Error("Failed to answer inline query", "model", model, "error", err)
+		d.sendInlineNotice(ctx, query, lang.Translate("inline.failed", conf.Lang))
+		return
+	}
+
+	d.inline.put(key, answer, time.Duration(conf.Inline.CacheTTL)*time.Second, conf.Inline.CacheSize)
+	logger.Info("Answered inline query", "model", model, "response_id", responseID)
+	d.sendInlineAnswer(ctx, query, question, answer)
+}
+
+// sendInlineAnswer offers the answer as the single result of the query.
+func (d *Dispatcher) sendInlineAnswer(ctx context.Context, query *tgbotapi.InlineQuery, question, answer string) {
+	if runes := []rune(answer); len(runes) > inlineAnswerLength {
+		answer = string(runes[:inlineAnswerLength]) + "…"
+	}
+	sum := sha256.Sum256([]byte(question + "\x00" + answer))
+	id := hex.EncodeToString(sum[:16])
+	text := fmt.Sprintf("<b>❓ %s</b>\n\n%s", html.EscapeString(question), render.ToHTML(answer))
+
+	result := tgbotapi.NewInlineQueryResultArticleHTML(id, preview(question), text)
+	result.Description = preview(answer)
+	d.sendInlineResults(ctx, query, []interface{}{result}, "")
+}
+
+// sendInlineNotice answers a query without results, showing text above the result list.
+// Tapping it opens the private chat with the bot.
+func (d *Dispatcher) sendInlineNotice(ctx context.Context, query *tgbotapi.InlineQuery, text string) {
+	d.sendInlineResults(ctx, query, []interface{}{}, text)
+}
+
+func (d *Dispatcher) sendInlineResults(ctx context.Context, query *tgbotapi.InlineQuery, results []interface{}, notice string) {
+	config := tgbotapi.InlineConfig{
+		InlineQueryID: query.ID,
+		Results:       results,
+		// Budgets are personal, so are the answers Telegram caches
+		IsPersonal:   true,
+		CacheTime:    d.conf().Inline.CacheTTL,
+		SwitchPMText: notice,
+	}
+	if notice != "" {
+		config.SwitchPMParameter = "inline"
+		config.CacheTime = 0
+	}
+	if _, err := d.bot.Request(config); err != nil {
+		// Answers that take too long are rejected, they are still cached for the next query
+		logging.From(ctx).Warn("Failed to answer inline query", "error", err)
+	}
+}
@@ -2,7 +2,7 @@ package lang
 
 import (
 	"encoding/json"
-	"log"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"strings"
@@ -33,17 +33,15 @@ func LoadTranslations(langDir string) error {
 
 	for _, lang := range languages {
 		filePath := filepath.Join(langDir, lang+".json")
-		log.Printf("Loading translations from: %s", filePath)
+		slog.Debug("Loaded translations", "file", filePath)
 	}
 
-	//log.Printf("Loaded translations: %+v", translations)
 	return nil
 }
 
 func Translate(key string, lang string) string {
-	//log.Printf("Translating key: %s, language: %s", key, lang)
 	if translations == nil {
-		log.Println("Translations not loaded. Did you call LoadTranslations?")
+		slog.Error("Translations not loaded, did you call LoadTranslations?")
 		return key
 	}
 	keys := strings.Split(key, ".")
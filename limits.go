@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"math"
+	"openrouter-gpt-telegram-bot/lang"
+	"openrouter-gpt-telegram-bot/logging"
+	"openrouter-gpt-telegram-bot/metrics"
+	"openrouter-gpt-telegram-bot/ratelimit"
+	"openrouter-gpt-telegram-bot/user"
+	"time"
+)
+
+// allowRequest applies the rate limits of the sender's role before a paid request
+// and asks the sender to slow down when they are exceeded.
+func (d *Dispatcher) allowRequest(ctx context.Context, message *tgbotapi.Message, sender *user.UsageTracker) bool {
+	ok, wait := d.checkRate(ctx, sender)
+	if ok {
+		return true
+	}
+	seconds := int(math.Ceil(wait.Seconds()))
+	d.bot.Send(newReply(message, fmt.Sprintf(lang.Translate("ratelimit.slow_down", d.conf().Lang), seconds)))
+	return false
+}
+
+// checkRate applies the rate limits of the sender's role and returns how long to wait
+// when they are exceeded.
+func (d *Dispatcher) checkRate(ctx context.Context, sender *user.UsageTracker) (bool, time.Duration) {
+	conf := d.conf()
+	role := sender.GetUserRole(conf)
+	limits := conf.RateLimit
+	userLimit := ratelimit.Limit{PerMinute: limits.PerUser[role], Burst: limits.Burst[role]}
+	roleLimit := ratelimit.Limit{PerMinute: limits.PerRole[role], Burst: limits.PerRole[role]}
+
+	ok, wait := d.limiter.Allow(sender.UserID, role, userLimit, roleLimit)
+	if !ok {
+		metrics.RateLimited.WithLabelValues("rate").Inc()
+		logging.From(ctx).Info("Request rate limited", "role", role, "retry_after", wait)
+	}
+	return ok, wait
+}
+
+// acquireStream waits for a free provider stream slot. If the queue is full or the
+// wait times out, the user is told to try again later and false is returned.
+// releaseStream must be called after a successful acquireStream.
+func (d *Dispatcher) acquireStream(ctx context.Context, message *tgbotapi.Message) bool {
+	conf := d.conf()
+	waitCtx, cancel := context.WithTimeout(ctx, time.Duration(conf.RateLimit.QueueTimeout)*time.Second)
+	defer cancel()
+
+	metrics.QueuedStreams.Inc()
+	err := d.streams.Acquire(waitCtx, conf.RateLimit.MaxStreams, conf.RateLimit.QueueSize)
+	metrics.QueuedStreams.Dec()
+	if err == nil {
+		return true
+	}
+	if ctx.Err() != nil {
+		// The turn was canceled while waiting, nobody is waiting for a reply
+		return false
+	}
+
+	reason := "queue_timeout"
+	if errors.Is(err, ratelimit.ErrQueueFull) {
+		reason = "queue_full"
+	}
+	metrics.RateLimited.WithLabelValues(reason).Inc()
+	logging.From(ctx).Warn("No free stream slot", "reason", reason)
+	d.bot.Send(newReply(message, lang.Translate("ratelimit.busy", conf.Lang)))
+	return false
+}
+
+func (d *Dispatcher) releaseStream() {
+	d.streams.Release(d.conf().RateLimit.MaxStreams)
+}
@@ -0,0 +1,71 @@
+// Package logging sets up structured logging and carries per-request loggers in contexts.
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+// level is shared by all handlers so it can be changed on config reload.
+var level = new(slog.LevelVar)
+
+// Setup installs the default logger writing to stderr in the given format, text or json.
+// Output of the standard log package goes to the same handler.
+func Setup(levelName, format string) error {
+	if err := SetLevel(levelName); err != nil {
+		return err
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	switch format {
+	case "text", "":
+		handler = slog.NewTextHandler(os.Stderr, opts)
+	case "json":
+		handler = slog.NewJSONHandler(os.Stderr, opts)
+	default:
+		return fmt.Errorf("unknown log format %q, expected text or json", format)
+	}
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+// SetLevel changes the minimum level of logged records: debug, info, warn or error.
+func SetLevel(name string) error {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(name)); err != nil {
+		return fmt.Errorf("unknown log level %q: %w", name, err)
+	}
+	level.Set(l)
+	return nil
+}
+
+type loggerKey struct{}
+
+// NewRequest returns a context carrying a logger tagged with a new request ID and attrs.
+func NewRequest(ctx context.Context, attrs ...any) context.Context {
+	logger := slog.Default().With("request_id", newRequestID()).With(attrs...)
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// With returns a context whose logger has attrs added.
+func With(ctx context.Context, attrs ...any) context.Context {
+	return context.WithValue(ctx, loggerKey{}, From(ctx).With(attrs...))
+}
+
+// From returns the logger carried by ctx, or the default logger.
+func From(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+func newRequestID() string {
+	b := make([]byte, 6)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
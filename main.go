@@ -1,149 +1,119 @@
 package main
 
 import (
-	"fmt"
+	"context"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
-	"github.com/sashabaranov/go-openai"
-	"log"
-	"openrouter-gpt-telegram-bot/api"
+	"log/slog"
+	"net/http"
 	"openrouter-gpt-telegram-bot/config"
 	"openrouter-gpt-telegram-bot/lang"
+	"openrouter-gpt-telegram-bot/logging"
+	"openrouter-gpt-telegram-bot/metrics"
+	"openrouter-gpt-telegram-bot/provider"
+	"openrouter-gpt-telegram-bot/tokenizer"
+	"openrouter-gpt-telegram-bot/transport"
 	"openrouter-gpt-telegram-bot/user"
-	"strconv"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
 )
 
 func main() {
 	err := lang.LoadTranslations("./lang/")
 	if err != nil {
-		log.Fatalf("Error loading translations: %v", err)
+		fatal("Error loading translations", err)
 	}
 
 	manager, err := config.NewManager("./config.yaml") // or the path to your config file
 	if err != nil {
-		log.Fatalf("Error initializing config manager: %v", err)
+		fatal("Error initializing config manager", err)
 	}
 
 	conf := manager.GetConfig()
+	if err := logging.Setup(conf.LogLevel, conf.LogFormat); err != nil {
+		fatal("Failed to set up logging", err)
+	}
+
+	if conf.Metrics.Listen != "" {
+		go metrics.Serve(conf.Metrics.Listen, conf.Metrics.Path)
+	}
 
-	bot, err := tgbotapi.NewBotAPI(conf.TelegramBotToken)
+	httpClient := &http.Client{Transport: metrics.TelegramTransport(http.DefaultTransport)}
+	bot, err := tgbotapi.NewBotAPIWithClient(conf.TelegramBotToken, conf.TelegramAPIEndpoint, httpClient)
 	if err != nil {
-		log.Panic(err)
+		fatal("Failed to create bot", err)
 	}
 	bot.Debug = false
 
-	// Delete the webhook
-	_, err = bot.Request(tgbotapi.DeleteWebhookConfig{})
+	//Set bot commands
+	err = registerCommands(bot, conf)
+	if err != nil {
+		fatal("Failed to set bot commands", err)
+	}
+
+	chatProvider, err := provider.New(conf)
 	if err != nil {
-		log.Fatalf("Failed to delete webhook: %v", err)
+		fatal("Failed to create provider", err)
 	}
 
-	// Now you can safely use getUpdates
-	u := tgbotapi.NewUpdate(0)
-	u.Timeout = 60
+	// The encoding is downloaded on first use, start early so it is ready for the first message
+	tokenizer.Preload(conf.Model.ModelName)
 
-	updates := bot.GetUpdatesChan(u)
+	historyStore, err := user.NewHistoryStore(conf)
+	if err != nil {
+		fatal("Failed to open history store", err)
+	}
+	defer historyStore.Close()
 
-	//Set bot commands
-	commands := []tgbotapi.BotCommand{
-		{Command: "start", Description: lang.Translate("description.start", conf.Lang)},
-		{Command: "help", Description: lang.Translate("description.help", conf.Lang)},
-		{Command: "reset", Description: lang.Translate("description.reset", conf.Lang)},
-		{Command: "stats", Description: lang.Translate("description.stats", conf.Lang)},
-		{Command: "stop", Description: lang.Translate("description.stop", conf.Lang)},
+	roster, err := user.LoadRoster(filepath.Join("logs", "roster.json"))
+	if err != nil {
+		fatal("Failed to load roster", err)
+	}
+
+	userManager := user.NewUserManager("logs", historyStore, roster)
+
+	updatesTransport, err := transport.New(bot, conf)
+	if err != nil {
+		fatal("Failed to create transport", err)
 	}
-	_, err = bot.Request(tgbotapi.NewSetMyCommands(commands...))
+	updates, err := updatesTransport.Start()
 	if err != nil {
-		log.Fatalf("Failed to set bot commands: %v", err)
+		fatal("Failed to start transport", err, "transport", conf.Transport.Mode)
 	}
 
-	clientOptions := openai.DefaultConfig(conf.OpenAIApiKey)
-	clientOptions.BaseURL = conf.OpenAIBaseURL
-	client := openai.NewClientWithConfig(clientOptions)
-
-	userManager := user.NewUserManager("logs")
-
-	for update := range updates {
-		if update.Message == nil {
-			continue
-		}
-		userStats := userManager.GetUser(update.SentFrom().ID, update.SentFrom().UserName, conf)
-		//userStats.AddCost(0.0)
-		if update.Message.IsCommand() {
-			switch update.Message.Command() {
-			case "start":
-				msgText := lang.Translate("commands.start", conf.Lang) + lang.Translate("commands.help", conf.Lang) + lang.Translate("commands.start_end", conf.Lang)
-				msg := tgbotapi.NewMessage(update.Message.Chat.ID, msgText)
-				msg.ParseMode = "HTML"
-				bot.Send(msg)
-			case "help":
-				msg := tgbotapi.NewMessage(update.Message.Chat.ID, lang.Translate("commands.help", conf.Lang))
-				msg.ParseMode = "HTML"
-				bot.Send(msg)
-			case "reset":
-				args := update.Message.CommandArguments()
-				msg := tgbotapi.NewMessage(update.Message.Chat.ID, "")
-
-				if args == "system" {
-					userStats.SystemPrompt = conf.SystemPrompt
-					msg.Text = lang.Translate("commands.reset_system", conf.Lang)
-				} else if args != "" {
-					userStats.SystemPrompt = args
-					msg.Text = lang.Translate("commands.reset_prompt", conf.Lang) + args + "."
-				} else {
-					userStats.ClearHistory()
-					msg.Text = lang.Translate("commands.reset", conf.Lang)
-				}
-				bot.Send(msg)
-			case "stats":
-				userStats.CheckHistory(conf.MaxHistorySize, conf.MaxHistoryTime)
-				countedUsage := strconv.FormatFloat(userStats.GetCurrentCost(conf.BudgetPeriod), 'f', 6, 64)
-				todayUsage := strconv.FormatFloat(userStats.GetCurrentCost("daily"), 'f', 6, 64)
-				monthUsage := strconv.FormatFloat(userStats.GetCurrentCost("monthly"), 'f', 6, 64)
-				totalUsage := strconv.FormatFloat(userStats.GetCurrentCost("total"), 'f', 6, 64)
-				messagesCount := strconv.Itoa(len(userStats.GetMessages()))
-
-				var statsMessage string
-				if userStats.CanViewStats(conf) {
-					statsMessage = fmt.Sprintf(
-						lang.Translate("commands.stats", conf.Lang),
-						countedUsage, todayUsage, monthUsage, totalUsage, messagesCount)
-				} else {
-					statsMessage = fmt.Sprintf(
-						lang.Translate("commands.stats_min", conf.Lang), messagesCount)
-				}
-
-				msg := tgbotapi.NewMessage(update.Message.Chat.ID, statsMessage)
-				msg.ParseMode = "HTML"
-				bot.Send(msg)
-
-			case "stop":
-				if userStats.CurrentStream != nil {
-					userStats.CurrentStream.Close()
-					msg := tgbotapi.NewMessage(update.Message.Chat.ID, lang.Translate("commands.stop", conf.Lang))
-					bot.Send(msg)
-				} else {
-					msg := tgbotapi.NewMessage(update.Message.Chat.ID, lang.Translate("commands.stop_err", conf.Lang))
-					bot.Send(msg)
-				}
-			}
-		} else {
-			go func(userStats *user.UsageTracker) {
-				// Handle user message
-				if userStats.HaveAccess(conf) {
-					responseID := api.HandleChatGPTStreamResponse(bot, client, update.Message, conf, userStats)
-					if conf.Model.Type == "openrouter" {
-						userStats.GetUsageFromApi(responseID, conf)
-					}
-				} else {
-					msg := tgbotapi.NewMessage(update.Message.Chat.ID, lang.Translate("budget_out", conf.Lang))
-					_, err := bot.Send(msg)
-					if err != nil {
-						log.Println(err)
-					}
-				}
-
-			}(userStats)
-		}
+	dispatcher := NewDispatcher(bot, chatProvider, manager, userManager)
+	go dispatcher.WatchConfig(manager.Subscribe())
+
+	signals, stopSignals := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	dispatcher.Run(signals, updates)
+	// A second signal kills the bot without waiting
+	stopSignals()
+	if signals.Err() != nil {
+		slog.Info("Shutting down")
+	} else {
+		slog.Warn("Updates channel closed, shutting down")
 	}
+	updatesTransport.Stop()
+	transportErr := updatesTransport.Err()
+	if transportErr != nil {
+		slog.Error("Transport failed", "error", transportErr)
+	}
+
+	dispatcher.Shutdown(time.Duration(manager.GetConfig().ShutdownTimeout) * time.Second)
+	if err := userManager.Flush(); err != nil {
+		slog.Error("Failed to save usage", "error", err)
+	}
+	slog.Info("Shutdown complete")
+	if transportErr != nil {
+		historyStore.Close()
+		os.Exit(1)
+	}
+}
 
+// fatal logs an error that prevents the bot from starting and exits.
+func fatal(msg string, err error, attrs ...any) {
+	slog.Error(msg, append([]any{"error", err}, attrs...)...)
+	os.Exit(1)
 }
@@ -0,0 +1,101 @@
+// Package metrics exposes Prometheus metrics of the bot.
+package metrics
+
+import (
+	"errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+var (
+	UpdatesReceived = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "bot_updates_received_total",
+		Help: "Telegram updates received by type.",
+	}, []string{"type"})
+
+	CommandsExecuted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "bot_commands_executed_total",
+		Help: "Bot commands executed by command.",
+	}, []string{"command"})
+
+	ProviderRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "bot_provider_requests_total",
+		Help: "Requests to the model provider by model and outcome: success, retried or failed.",
+	}, []string{"model", "outcome"})
+
+	TimeToFirstToken = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "bot_time_to_first_token_seconds",
+		Help:    "Time from sending a request to receiving the first answer token.",
+		Buckets: []float64{0.25, 0.5, 1, 2, 4, 8, 15, 30, 60},
+	}, []string{"model"})
+
+	StreamDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "bot_stream_duration_seconds",
+		Help:    "Time from sending a request to the end of the streamed answer.",
+		Buckets: []float64{1, 2, 5, 10, 20, 40, 80, 160, 320},
+	}, []string{"model"})
+
+	ActiveStreams = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "bot_active_streams",
+		Help: "Answers currently being streamed.",
+	})
+
+	RateLimited = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "bot_rate_limited_total",
+		Help: "Requests turned away by reason: rate, queue_full or queue_timeout.",
+	}, []string{"reason"})
+
+	QueuedStreams = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "bot_queued_streams",
+		Help: "Answers waiting for a free stream slot.",
+	})
+
+	TelegramErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "bot_telegram_api_errors_total",
+		Help: "Failed Telegram Bot API calls by method and HTTP status, 0 for network errors.",
+	}, []string{"method", "status"})
+
+	Spend = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "bot_spend_usd_total",
+		Help: "Cost charged to users in USD by role.",
+	}, []string{"role"})
+)
+
+// Serve serves the metrics at path on addr until the server fails.
+func Serve(addr, path string) {
+	mux := http.NewServeMux()
+	mux.Handle(path, promhttp.Handler())
+	server := &http.Server{Addr: addr, Handler: mux}
+	slog.Info("Serving metrics", "addr", addr, "path", path)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("Metrics server failed", "error", err)
+	}
+}
+
+// TelegramTransport counts failed Bot API calls made through base.
+func TelegramTransport(base http.RoundTripper) http.RoundTripper {
+	return telegramTransport{base: base}
+}
+
+type telegramTransport struct {
+	base http.RoundTripper
+}
+
+func (t telegramTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// The method is the last path segment, as in /bot<token>/sendMessage
+	method := req.URL.Path[strings.LastIndex(req.URL.Path, "/")+1:]
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		TelegramErrors.WithLabelValues(method, "0").Inc()
+		return nil, err
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		TelegramErrors.WithLabelValues(method, strconv.Itoa(resp.StatusCode)).Inc()
+	}
+	return resp, nil
+}
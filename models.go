@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"openrouter-gpt-telegram-bot/lang"
+	"openrouter-gpt-telegram-bot/logging"
+	"openrouter-gpt-telegram-bot/provider"
+	"openrouter-gpt-telegram-bot/transport"
+	"openrouter-gpt-telegram-bot/user"
+	"sync"
+	"time"
+)
+
+const (
+	// modelCatalogTTL is how long the provider model list is cached.
+	modelCatalogTTL = time.Hour
+	// maxModelButtons keeps the /model keyboard usable when wildcards match many models.
+	maxModelButtons = 40
+	// maxCallbackData is the Telegram limit for inline button callback data in bytes.
+	maxCallbackData = 64
+)
+
+// modelCatalog caches the models offered by the provider.
+type modelCatalog struct {
+	provider provider.Provider
+	models   []provider.Model
+	fetched  time.Time
+	mu       sync.Mutex
+}
+
+func newModelCatalog(p provider.Provider) *modelCatalog {
+	return &modelCatalog{provider: p}
+}
+
+// Models returns the cached catalog, refreshing it when it is older than modelCatalogTTL.
+func (c *modelCatalog) Models() ([]provider.Model, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.models != nil && time.Since(c.fetched) < modelCatalogTTL {
+		return c.models, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	models, err := c.provider.ListModels(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.models = models
+	c.fetched = time.Now()
+	return models, nil
+}
+
+// allowedModels returns the models a role may pick, the default model first.
+// If the catalog cannot be fetched, the exact model IDs from the allowlist are used.
+func (d *Dispatcher) allowedModels(ctx context.Context, role string) []provider.Model {
+	models := []provider.Model{{ID: d.conf().Model.ModelName, Name: d.conf().Model.ModelName}}
+
+	catalog, err := d.modelCatalog().Models()
+	if err != nil {
+		logging.From(ctx).Error("Failed to list models", "error", err)
+		catalog = nil
+		for _, id := range d.conf().AllowedModels[role] {
+			catalog = append(catalog, provider.Model{ID: id, Name: id})
+		}
+	}
+
+	for _, m := range catalog {
+		if len(models) >= maxModelButtons {
+			break
+		}
+		if m.ID == d.conf().Model.ModelName || len("model:"+m.ID) > maxCallbackData {
+			continue
+		}
+		if d.conf().ModelAllowed(role, m.ID) {
+			if m.Name == "" {
+				m.Name = m.ID
+			}
+			models = append(models, m)
+		}
+	}
+	return models
+}
+
+// handleModelCommand shows the models the sender may pick as an inline keyboard.
+func (d *Dispatcher) handleModelCommand(ctx context.Context, message *tgbotapi.Message, sender, conversation *user.UsageTracker) {
+	conf := d.conf()
+	models := d.allowedModels(ctx, sender.GetUserRole(conf))
+	current := conversation.ModelFor(conf, sender.GetUserRole(conf))
+
+	if len(models) < 2 {
+		msg := newReply(message, fmt.Sprintf(lang.Translate("commands.model_none", conf.Lang), current))
+		msg.ParseMode = "HTML"
+		d.bot.Send(msg)
+		return
+	}
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, m := range models {
+		label := m.Name
+		if m.ID == current {
+			label = "✅ " + label
+		}
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(label, "model:"+m.ID)))
+	}
+
+	msg := newReply(message, fmt.Sprintf(lang.Translate("commands.model", conf.Lang), current))
+	msg.ParseMode = "HTML"
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+	if _, err := d.bot.Send(msg); err != nil {
+		logging.From(ctx).Error("Failed to send model list", "error", err)
+	}
+}
+
+// handleModelCallback stores the model picked from the /model keyboard.
+func (d *Dispatcher) handleModelCallback(ctx context.Context, update transport.Update, model string) {
+	conf := d.conf()
+	query := update.CallbackQuery
+	sender, conversation, _ := d.trackers(query.From, query.Message.Chat, update.MessageThreadID)
+
+	// The keyboard may be older than the allowlist, so the choice is checked again
+	if !conf.ModelAllowed(sender.GetUserRole(conf), model) {
+		d.answerCallback(ctx, query, lang.Translate("commands.model_denied", conf.Lang))
+		return
+	}
+
+	if model == conf.Model.ModelName {
+		// Following the default keeps the conversation on it when the config changes,
+		// and the model of a persona does not replace it
+		conversation.SetModel(user.DefaultModel)
+	} else {
+		conversation.SetModel(model)
+	}
+
+	text := fmt.Sprintf(lang.Translate("commands.model_set", conf.Lang), model)
+	d.answerCallback(ctx, query, "")
+	edit := tgbotapi.NewEditMessageText(query.Message.Chat.ID, query.Message.MessageID, text)
+	if _, err := d.bot.Send(edit); err != nil {
+		logging.From(ctx).Error("Failed to edit model list", "error", err)
+	}
+}
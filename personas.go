@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"html"
+	"openrouter-gpt-telegram-bot/lang"
+	"openrouter-gpt-telegram-bot/logging"
+	"openrouter-gpt-telegram-bot/transport"
+	"openrouter-gpt-telegram-bot/user"
+)
+
+// handlePersonaCommand shows the configured personas as an inline keyboard.
+func (d *Dispatcher) handlePersonaCommand(ctx context.Context, message *tgbotapi.Message, conversation *user.UsageTracker) {
+	conf := d.conf()
+	if len(conf.Personas) == 0 {
+		d.sendHTML(ctx, message, lang.Translate("persona.none", conf.Lang))
+		return
+	}
+
+	current, picked := conversation.Persona(conf)
+	currentName := lang.Translate("persona.default", conf.Lang)
+	defaultLabel := currentName
+	if picked {
+		currentName = current.Name
+	} else {
+		defaultLabel = "✅ " + defaultLabel
+	}
+	rows := [][]tgbotapi.InlineKeyboardButton{
+		tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(defaultLabel, "persona:")),
+	}
+	for _, persona := range conf.Personas {
+		if len("persona:"+persona.ID) > maxCallbackData {
+			continue
+		}
+		label := persona.Name
+		if picked && persona.ID == current.ID {
+			label = "✅ " + label
+		}
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(label, "persona:"+persona.ID)))
+	}
+
+	msg := newReply(message, fmt.Sprintf(lang.Translate("persona.pick", conf.Lang), html.EscapeString(currentName)))
+	msg.ParseMode = "HTML"
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+	if _, err := d.bot.Send(msg); err != nil {
+		logging.From(ctx).Error("Failed to send persona list", "error", err)
+	}
+}
+
+// handlePersonaCallback stores the persona picked from the /persona keyboard and shows its greeting.
+func (d *Dispatcher) handlePersonaCallback(ctx context.Context, update transport.Update, id string) {
+	conf := d.conf()
+	query := update.CallbackQuery
+	_, conversation, _ := d.trackers(query.From, query.Message.Chat, update.MessageThreadID)
+
+	name := lang.Translate("persona.default", conf.Lang)
+	greeting := ""
+	if id != "" {
+		// The keyboard may be older than the config
+		persona, ok := conf.Persona(id)
+		if !ok {
+			d.answerCallback(ctx, query, lang.Translate("persona.unknown", conf.Lang))
+			return
+		}
+		name, greeting = persona.Name, persona.Greeting
+	}
+	conversation.SetPersona(id)
+	logging.From(ctx).Info("Persona picked", "persona", id)
+
+	text := fmt.Sprintf(lang.Translate("persona.set", conf.Lang), html.EscapeString(name))
+	if greeting != "" {
+		text += "\n\n" + html.EscapeString(greeting)
+	}
+	d.answerCallback(ctx, query, "")
+	edit := tgbotapi.NewEditMessageText(query.Message.Chat.ID, query.Message.MessageID, text)
+	edit.ParseMode = tgbotapi.ModeHTML
+	if _, err := d.bot.Send(edit); err != nil {
+		logging.From(ctx).Error("Failed to edit persona list", "error", err)
+	}
+}
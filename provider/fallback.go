@@ -0,0 +1,63 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+)
+
+type fallbackKey struct{}
+
+// WithFallbackModels returns a context asking providers with native fallback support
+// to try models, in order, when the requested model fails. Other providers ignore it.
+func WithFallbackModels(ctx context.Context, models []string) context.Context {
+	return context.WithValue(ctx, fallbackKey{}, models)
+}
+
+func fallbackModels(ctx context.Context) []string {
+	models, _ := ctx.Value(fallbackKey{}).([]string)
+	return models
+}
+
+// fallbackTransport adds the fallback models of the request context to chat completion
+// requests as OpenRouter's models field, which go-openai has no field for.
+type fallbackTransport struct {
+	base http.RoundTripper
+}
+
+func (t fallbackTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	models := fallbackModels(req.Context())
+	if len(models) == 0 || req.Body == nil || req.Method != http.MethodPost ||
+		!strings.HasSuffix(req.URL.Path, "/chat/completions") {
+		return t.base.RoundTrip(req)
+	}
+
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil, err
+	}
+	fields["models"], err = json.Marshal(models)
+	if err != nil {
+		return nil, err
+	}
+	body, err = json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+
+	req = req.Clone(req.Context())
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+	return t.base.RoundTrip(req)
+}
@@ -0,0 +1,86 @@
+package provider
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"github.com/sashabaranov/go-openai"
+	"net/http"
+)
+
+// OpenAI is a generic OpenAI-compatible provider.
+type OpenAI struct {
+	client *openai.Client
+}
+
+func NewOpenAI(apiKey, baseURL string) *OpenAI {
+	return newOpenAI(apiKey, baseURL, nil)
+}
+
+// newOpenAI creates the provider with a custom HTTP client, nil uses the default one.
+func newOpenAI(apiKey, baseURL string, httpClient *http.Client) *OpenAI {
+	clientOptions := openai.DefaultConfig(apiKey)
+	clientOptions.BaseURL = baseURL
+	if httpClient != nil {
+		clientOptions.HTTPClient = httpClient
+	}
+	return &OpenAI{client: openai.NewClientWithConfig(clientOptions)}
+}
+
+func (p *OpenAI) Name() string {
+	return "openai"
+}
+
+func (p *OpenAI) ChatStream(ctx context.Context, req openai.ChatCompletionRequest) (Stream, error) {
+	req.Stream = true
+	return p.client.CreateChatCompletionStream(ctx, req)
+}
+
+func (p *OpenAI) Chat(ctx context.Context, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	req.Stream = false
+	return p.client.CreateChatCompletion(ctx, req)
+}
+
+// GenerationCost is not available from the OpenAI API.
+func (p *OpenAI) GenerationCost(ctx context.Context, id string) (float64, error) {
+	return 0, ErrNotSupported
+}
+
+func (p *OpenAI) ListModels(ctx context.Context) ([]Model, error) {
+	list, err := p.client.ListModels(ctx)
+	if err != nil {
+		return nil, err
+	}
+	models := make([]Model, 0, len(list.Models))
+	for _, m := range list.Models {
+		models = append(models, Model{ID: m.ID, Name: m.ID})
+	}
+	return models, nil
+}
+
+// GenerateImage uses the images API.
+func (p *OpenAI) GenerateImage(ctx context.Context, model, prompt, size string) (ImageResult, error) {
+	resp, err := p.client.CreateImage(ctx, openai.ImageRequest{
+		Prompt:         prompt,
+		Model:          model,
+		N:              1,
+		Size:           size,
+		ResponseFormat: openai.CreateImageResponseFormatB64JSON,
+	})
+	if err != nil {
+		return ImageResult{}, err
+	}
+	var result ImageResult
+	for _, img := range resp.Data {
+		if img.B64JSON == "" {
+			result.Images = append(result.Images, Image{URL: img.URL})
+			continue
+		}
+		data, err := base64.StdEncoding.DecodeString(img.B64JSON)
+		if err != nil {
+			return ImageResult{}, fmt.Errorf("error decoding image: %w", err)
+		}
+		result.Images = append(result.Images, Image{Data: data})
+	}
+	return result, nil
+}
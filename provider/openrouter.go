@@ -0,0 +1,215 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OpenRouter talks to the OpenRouter API. Chat completions use the OpenAI-compatible
+// endpoints, cost lookup and the model catalog use OpenRouter's own endpoints. Images
+// come from image output models through raw chat completion requests.
+type OpenRouter struct {
+	*OpenAI
+	apiKey     string
+	baseURL    string
+	httpClient *http.Client
+	// imageClient allows for the longer time image generation takes
+	imageClient *http.Client
+}
+
+func NewOpenRouter(apiKey, baseURL string) *OpenRouter {
+	return &OpenRouter{
+		OpenAI:      newOpenAI(apiKey, baseURL, &http.Client{Transport: fallbackTransport{http.DefaultTransport}}),
+		apiKey:      apiKey,
+		baseURL:     strings.TrimRight(baseURL, "/"),
+		httpClient:  &http.Client{Timeout: 30 * time.Second},
+		imageClient: &http.Client{Timeout: 3 * time.Minute},
+	}
+}
+
+func (p *OpenRouter) Name() string {
+	return "openrouter"
+}
+
+type generationResponse struct {
+	Data generationData `json:"data"`
+}
+
+type generationData struct {
+	ID                     string  `json:"id"`
+	Model                  string  `json:"model"`
+	Streamed               bool    `json:"streamed"`
+	GenerationTime         int     `json:"generation_time"`
+	CreatedAt              string  `json:"created_at"`
+	TokensPrompt           int     `json:"tokens_prompt"`
+	TokensCompletion       int     `json:"tokens_completion"`
+	NativeTokensPrompt     int     `json:"native_tokens_prompt"`
+	NativeTokensCompletion int     `json:"native_tokens_completion"`
+	NumMediaPrompt         int     `json:"num_media_prompt"`
+	NumMediaCompletion     int     `json:"num_media_completion"`
+	Origin                 string  `json:"origin"`
+	TotalCost              float64 `json:"total_cost"`
+}
+
+// GenerationCost gets the cost of a generation from the /generation endpoint.
+func (p *OpenRouter) GenerationCost(ctx context.Context, id string) (float64, error) {
+	var generation generationResponse
+	err := p.get(ctx, "/generation?id="+url.QueryEscape(id), &generation)
+	if err != nil {
+		return 0, err
+	}
+	return generation.Data.TotalCost, nil
+}
+
+type modelsResponse struct {
+	Data []modelData `json:"data"`
+}
+
+type modelData struct {
+	ID            string `json:"id"`
+	Name          string `json:"name"`
+	ContextLength int    `json:"context_length"`
+	Pricing       struct {
+		Prompt     string `json:"prompt"`
+		Completion string `json:"completion"`
+	} `json:"pricing"`
+}
+
+// ListModels returns the OpenRouter model catalog.
+func (p *OpenRouter) ListModels(ctx context.Context) ([]Model, error) {
+	var catalog modelsResponse
+	err := p.get(ctx, "/models", &catalog)
+	if err != nil {
+		return nil, err
+	}
+	models := make([]Model, 0, len(catalog.Data))
+	for _, m := range catalog.Data {
+		// Prices are sent as decimal strings, unparsable ones are treated as unknown
+		promptPrice, _ := strconv.ParseFloat(m.Pricing.Prompt, 64)
+		completionPrice, _ := strconv.ParseFloat(m.Pricing.Completion, 64)
+		models = append(models, Model{
+			ID:              m.ID,
+			Name:            m.Name,
+			ContextLength:   m.ContextLength,
+			PromptPrice:     promptPrice,
+			CompletionPrice: completionPrice,
+		})
+	}
+	return models, nil
+}
+
+type imageRequest struct {
+	Model      string         `json:"model"`
+	Messages   []imageMessage `json:"messages"`
+	Modalities []string       `json:"modalities"`
+}
+
+type imageMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type imageResponse struct {
+	ID      string `json:"id"`
+	Choices []struct {
+		Message struct {
+			Images []struct {
+				ImageURL struct {
+					URL string `json:"url"`
+				} `json:"image_url"`
+			} `json:"images"`
+		} `json:"message"`
+	} `json:"choices"`
+}
+
+// GenerateImage asks an image output model for an image through the chat completions
+// endpoint. Images are returned as base64 data URLs.
+func (p *OpenRouter) GenerateImage(ctx context.Context, model, prompt, size string) (ImageResult, error) {
+	req := imageRequest{
+		Model:      model,
+		Messages:   []imageMessage{{Role: "user", Content: prompt}},
+		Modalities: []string{"image", "text"},
+	}
+	var resp imageResponse
+	if err := p.post(ctx, p.imageClient, "/chat/completions", req, &resp); err != nil {
+		return ImageResult{}, err
+	}
+
+	result := ImageResult{GenerationID: resp.ID}
+	for _, choice := range resp.Choices {
+		for _, img := range choice.Message.Images {
+			image, err := decodeDataURL(img.ImageURL.URL)
+			if err != nil {
+				return ImageResult{}, err
+			}
+			result.Images = append(result.Images, image)
+		}
+	}
+	if len(result.Images) == 0 {
+		return ImageResult{}, fmt.Errorf("model %s returned no image", model)
+	}
+	return result, nil
+}
+
+// decodeDataURL returns the image in a base64 data URL, other URLs are returned as is.
+func decodeDataURL(url string) (Image, error) {
+	if !strings.HasPrefix(url, "data:") {
+		return Image{URL: url}, nil
+	}
+	_, encoded, ok := strings.Cut(url, ";base64,")
+	if !ok {
+		return Image{}, fmt.Errorf("unsupported data URL")
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return Image{}, fmt.Errorf("error decoding image: %w", err)
+	}
+	return Image{Data: data}, nil
+}
+
+// get sends an authorized GET request to the OpenRouter API and decodes the JSON response into v.
+func (p *OpenRouter) get(ctx context.Context, path string, v interface{}) error {
+	return p.do(ctx, p.httpClient, http.MethodGet, path, nil, v)
+}
+
+// post sends body as JSON to the OpenRouter API and decodes the JSON response into v.
+func (p *OpenRouter) post(ctx context.Context, client *http.Client, path string, body, v interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("error encoding request: %w", err)
+	}
+	return p.do(ctx, client, http.MethodPost, path, data, v)
+}
+
+func (p *OpenRouter) do(ctx context.Context, client *http.Client, method, path string, body []byte, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, p.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error creating request: %w", err)
+	}
+	req.Header.Add("Authorization", "Bearer "+p.apiKey)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s from %s", resp.Status, path)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("error decoding response: %w", err)
+	}
+	return nil
+}
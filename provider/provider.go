@@ -0,0 +1,71 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/sashabaranov/go-openai"
+	"openrouter-gpt-telegram-bot/config"
+)
+
+// ErrNotSupported is returned when a provider does not implement an optional capability.
+var ErrNotSupported = errors.New("not supported by provider")
+
+// Stream is a server-sent stream of chat completion chunks.
+// *openai.ChatCompletionStream satisfies it.
+type Stream interface {
+	Recv() (openai.ChatCompletionStreamResponse, error)
+	Close() error
+}
+
+// Model describes a model offered by a provider. Prices are in USD per token
+// and are zero when the provider does not publish them.
+type Model struct {
+	ID              string
+	Name            string
+	ContextLength   int
+	PromptPrice     float64
+	CompletionPrice float64
+}
+
+// Image is a generated image, given either as data or as a URL to download it from.
+type Image struct {
+	Data []byte
+	URL  string
+}
+
+// ImageResult holds generated images. GenerationID identifies the generation for
+// GenerationCost and is empty when the provider does not report costs.
+type ImageResult struct {
+	Images       []Image
+	GenerationID string
+}
+
+// Provider is a chat completion backend.
+type Provider interface {
+	// Name returns the provider type as used in configuration.
+	Name() string
+	// ChatStream starts a streaming chat completion.
+	ChatStream(ctx context.Context, req openai.ChatCompletionRequest) (Stream, error)
+	// Chat runs a chat completion and waits for the whole answer.
+	Chat(ctx context.Context, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error)
+	// GenerationCost returns the cost in USD of a finished generation.
+	GenerationCost(ctx context.Context, id string) (float64, error)
+	// ListModels returns the models available through the provider.
+	ListModels(ctx context.Context) ([]Model, error)
+	// GenerateImage creates an image for prompt. Size is a hint such as 1024x1024
+	// that providers without a size setting ignore.
+	GenerateImage(ctx context.Context, model, prompt, size string) (ImageResult, error)
+}
+
+// New returns the provider selected by conf.Model.Type.
+func New(conf *config.Config) (Provider, error) {
+	switch conf.Model.Type {
+	case "openrouter":
+		return NewOpenRouter(conf.OpenAIApiKey, conf.OpenAIBaseURL), nil
+	case "openai", "":
+		return NewOpenAI(conf.OpenAIApiKey, conf.OpenAIBaseURL), nil
+	default:
+		return nil, fmt.Errorf("unknown provider type: %s", conf.Model.Type)
+	}
+}
@@ -0,0 +1,90 @@
+// Package ratelimit limits how often users may start paid requests and how many
+// provider streams run at once.
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// bucket is a token bucket refilled continuously at rate tokens per second.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// take removes a token if one is available. Otherwise it returns how long it takes
+// until the next token is.
+func (b *bucket) take(now time.Time, rate, burst float64) (bool, time.Duration) {
+	if b.last.IsZero() {
+		b.tokens = burst
+	} else {
+		b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*rate)
+	}
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / rate * float64(time.Second))
+}
+
+// Limit is a rate in requests per minute with a burst size. A zero rate means no limit.
+type Limit struct {
+	PerMinute int
+	Burst     int
+}
+
+// Limiter keeps a token bucket per user and per role. The limits are passed on every
+// call so configuration changes apply to existing buckets.
+type Limiter struct {
+	users map[string]*bucket
+	roles map[string]*bucket
+	mu    sync.Mutex
+}
+
+func NewLimiter() *Limiter {
+	return &Limiter{users: make(map[string]*bucket), roles: make(map[string]*bucket)}
+}
+
+// Allow takes a token from the bucket of the user and the shared bucket of its role.
+// If either is empty, nothing is taken and the time until a retry may succeed is returned.
+func (l *Limiter) Allow(userID, role string, user, shared Limit) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+
+	// Check the user bucket on a copy, so a request rejected by the role bucket
+	// does not use up the user's tokens
+	userBucket := l.bucket(l.users, userID)
+	userCopy := *userBucket
+	if ok, wait := take(&userCopy, now, user); !ok {
+		return false, wait
+	}
+	if ok, wait := take(l.bucket(l.roles, role), now, shared); !ok {
+		return false, wait
+	}
+	*userBucket = userCopy
+	return true, 0
+}
+
+func (l *Limiter) bucket(buckets map[string]*bucket, key string) *bucket {
+	b, ok := buckets[key]
+	if !ok {
+		b = &bucket{}
+		buckets[key] = b
+	}
+	return b
+}
+
+func take(b *bucket, now time.Time, limit Limit) (bool, time.Duration) {
+	if limit.PerMinute <= 0 {
+		return true, 0
+	}
+	burst := float64(limit.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+	return b.take(now, float64(limit.PerMinute)/60, burst)
+}
@@ -0,0 +1,83 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrQueueFull is returned when a slot is requested while the waiting queue is full.
+var ErrQueueFull = errors.New("stream queue is full")
+
+// Pool caps the number of concurrent provider streams. Requests beyond the cap wait
+// in a FIFO queue of limited length.
+type Pool struct {
+	active  int
+	waiting []chan struct{}
+	mu      sync.Mutex
+}
+
+func NewPool() *Pool {
+	return &Pool{}
+}
+
+// Acquire waits for a slot among capacity concurrent ones, zero meaning unlimited.
+// At most queueSize requests wait at a time. Release must be called when the
+// stream is done unless an error is returned.
+func (p *Pool) Acquire(ctx context.Context, capacity, queueSize int) error {
+	p.mu.Lock()
+	if capacity <= 0 || p.active < capacity && len(p.waiting) == 0 {
+		p.active++
+		p.mu.Unlock()
+		return nil
+	}
+	if len(p.waiting) >= queueSize {
+		p.mu.Unlock()
+		return ErrQueueFull
+	}
+	ready := make(chan struct{})
+	p.waiting = append(p.waiting, ready)
+	p.mu.Unlock()
+
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		for i, w := range p.waiting {
+			if w == ready {
+				p.waiting = append(p.waiting[:i], p.waiting[i+1:]...)
+				return ctx.Err()
+			}
+		}
+		// The slot was handed over while the context ended, pass it on
+		p.active--
+		p.wake(capacity)
+		return ctx.Err()
+	}
+}
+
+// Release frees a slot and hands it to the longest waiting request.
+func (p *Pool) Release(capacity int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.active--
+	p.wake(capacity)
+}
+
+// Queued returns the number of waiting requests.
+func (p *Pool) Queued() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.waiting)
+}
+
+// wake hands free slots to waiting requests. p.mu must be held.
+func (p *Pool) wake(capacity int) {
+	for len(p.waiting) > 0 && (capacity <= 0 || p.active < capacity) {
+		close(p.waiting[0])
+		p.waiting = p.waiting[1:]
+		p.active++
+	}
+}
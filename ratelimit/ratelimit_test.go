@@ -0,0 +1,143 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLimiterAllow(t *testing.T) {
+	tests := []struct {
+		name     string
+		user     Limit
+		shared   Limit
+		requests int
+		allowed  int
+	}{
+		{"no limits", Limit{}, Limit{}, 10, 10},
+		{"user burst", Limit{PerMinute: 1, Burst: 3}, Limit{}, 5, 3},
+		{"burst of at least one", Limit{PerMinute: 1}, Limit{}, 3, 1},
+		{"shared burst", Limit{}, Limit{PerMinute: 1, Burst: 2}, 5, 2},
+		{"stricter limit wins", Limit{PerMinute: 1, Burst: 4}, Limit{PerMinute: 1, Burst: 2}, 5, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewLimiter()
+			allowed := 0
+			for i := 0; i < tt.requests; i++ {
+				ok, wait := l.Allow("1", "user", tt.user, tt.shared)
+				switch {
+				case ok:
+					allowed++
+				case wait <= 0:
+					t.Fatalf("rejected request %d without a retry delay", i)
+				}
+			}
+			if allowed != tt.allowed {
+				t.Errorf("allowed %d of %d requests, want %d", allowed, tt.requests, tt.allowed)
+			}
+		})
+	}
+}
+
+func TestLimiterSharedRejectionKeepsUserTokens(t *testing.T) {
+	l := NewLimiter()
+	user := Limit{PerMinute: 1, Burst: 2}
+	shared := Limit{PerMinute: 1, Burst: 1}
+
+	if ok, _ := l.Allow("1", "user", user, shared); !ok {
+		t.Fatal("first request rejected")
+	}
+	// The role bucket is empty, the second user of the role is rejected
+	if ok, _ := l.Allow("2", "user", user, shared); ok {
+		t.Fatal("shared limit not applied")
+	}
+	// The rejected request did not take a token of user 2
+	if ok, _ := l.Allow("2", "other", user, shared); !ok {
+		t.Fatal("user tokens taken by a rejected request")
+	}
+	if ok, _ := l.Allow("2", "another", user, shared); !ok {
+		t.Fatal("user tokens taken by a rejected request")
+	}
+}
+
+func TestBucketRefill(t *testing.T) {
+	start := time.Now()
+	b := &bucket{}
+	if ok, _ := b.take(start, 1, 1); !ok {
+		t.Fatal("full bucket rejected a request")
+	}
+	ok, wait := b.take(start, 1, 1)
+	if ok || wait != time.Second {
+		t.Fatalf("take on an empty bucket = %v, %v, want false, 1s", ok, wait)
+	}
+	if ok, _ := b.take(start.Add(time.Second), 1, 1); !ok {
+		t.Fatal("bucket not refilled")
+	}
+}
+
+func TestPoolAcquire(t *testing.T) {
+	tests := []struct {
+		name      string
+		capacity  int
+		queueSize int
+		active    int
+		want      error
+	}{
+		{"unlimited", 0, 0, 5, nil},
+		{"free slot", 2, 0, 1, nil},
+		{"queue full", 1, 0, 1, ErrQueueFull},
+		{"waits in queue", 1, 1, 1, context.DeadlineExceeded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewPool()
+			for i := 0; i < tt.active; i++ {
+				if err := p.Acquire(context.Background(), tt.capacity, tt.queueSize); err != nil {
+					t.Fatalf("acquiring slot %d: %v", i, err)
+				}
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer cancel()
+			if err := p.Acquire(ctx, tt.capacity, tt.queueSize); !errors.Is(err, tt.want) {
+				t.Errorf("Acquire() = %v, want %v", err, tt.want)
+			}
+			if queued := p.Queued(); queued != 0 {
+				t.Errorf("%d requests left in the queue", queued)
+			}
+		})
+	}
+}
+
+func TestPoolReleaseWakesInOrder(t *testing.T) {
+	p := NewPool()
+	if err := p.Acquire(context.Background(), 1, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	order := make(chan int, 2)
+	for i := 1; i <= 2; i++ {
+		go func() {
+			if err := p.Acquire(context.Background(), 1, 2); err == nil {
+				order <- i
+			}
+		}()
+		// Queue the waiters one after the other
+		for p.Queued() < i {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	for want := 1; want <= 2; want++ {
+		p.Release(1)
+		select {
+		case got := <-order:
+			if got != want {
+				t.Fatalf("waiter %d woken, want %d", got, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("no waiter woken by Release")
+		}
+	}
+}
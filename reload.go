@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"html"
+	"log/slog"
+	"openrouter-gpt-telegram-bot/config"
+	"openrouter-gpt-telegram-bot/lang"
+	"openrouter-gpt-telegram-bot/logging"
+	"openrouter-gpt-telegram-bot/provider"
+	"openrouter-gpt-telegram-bot/tokenizer"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// registerCommands sets the bot command menu in the configured language.
+func registerCommands(bot *tgbotapi.BotAPI, conf *config.Config) error {
+	commands := []tgbotapi.BotCommand{
+		{Command: "start", Description: lang.Translate("description.start", conf.Lang)},
+		{Command: "help", Description: lang.Translate("description.help", conf.Lang)},
+		{Command: "reset", Description: lang.Translate("description.reset", conf.Lang)},
+		{Command: "stats", Description: lang.Translate("description.stats", conf.Lang)},
+		{Command: "model", Description: lang.Translate("description.model", conf.Lang)},
+		{Command: "persona", Description: lang.Translate("description.persona", conf.Lang)},
+		{Command: "history", Description: lang.Translate("description.history", conf.Lang)},
+		{Command: "stop", Description: lang.Translate("description.stop", conf.Lang)},
+		{Command: "image", Description: lang.Translate("description.image", conf.Lang)},
+	}
+	_, err := bot.Request(tgbotapi.NewSetMyCommands(commands...))
+	return err
+}
+
+// WatchConfig applies reloaded configurations until the channel is closed.
+func (d *Dispatcher) WatchConfig(reloads <-chan config.Reload) {
+	for reload := range reloads {
+		if reload.Err != nil {
+			text := fmt.Sprintf(lang.Translate("config.rejected", reload.Old.Lang), html.EscapeString(reload.Err.Error()))
+			d.notifyAdmins(reload.Old, text)
+			continue
+		}
+		d.applyConfig(reload.Old, reload.New)
+	}
+}
+
+// applyConfig updates what depends on the configuration and tells the admins what changed.
+// Settings read from the config on every update take effect without further work.
+func (d *Dispatcher) applyConfig(old, new *config.Config) {
+	changes := config.Diff(old, new)
+	if len(changes) == 0 {
+		return
+	}
+	slog.Info("Config reloaded", "changes", changes)
+
+	if old.Model.Type != new.Model.Type || old.OpenAIApiKey != new.OpenAIApiKey || old.OpenAIBaseURL != new.OpenAIBaseURL {
+		p, err := provider.New(new)
+		if err != nil {
+			slog.Error("Failed to create provider, keeping the previous one", "error", err)
+		} else {
+			d.mu.Lock()
+			d.chatProvider = p
+			d.catalog = newModelCatalog(p)
+			d.mu.Unlock()
+		}
+	}
+	if old.Lang != new.Lang {
+		if err := registerCommands(d.bot, new); err != nil {
+			slog.Error("Failed to set bot commands", "error", err)
+		}
+	}
+	if old.LogLevel != new.LogLevel {
+		if err := logging.SetLevel(new.LogLevel); err != nil {
+			slog.Error("Failed to set log level", "error", err)
+		}
+	}
+	if old.Model.ModelName != new.Model.ModelName {
+		tokenizer.Preload(new.Model.ModelName)
+	}
+
+	var text strings.Builder
+	text.WriteString(lang.Translate("config.reloaded", new.Lang))
+	for _, change := range changes {
+		text.WriteString("\n• " + html.EscapeString(change))
+	}
+	if restartRequired(old, new) {
+		text.WriteString("\n\n" + lang.Translate("config.restart", new.Lang))
+	}
+	d.notifyAdmins(new, text.String())
+}
+
+// restartRequired reports whether settings changed that are only read at startup.
+func restartRequired(old, new *config.Config) bool {
+	return old.TelegramBotToken != new.TelegramBotToken ||
+		old.TelegramAPIEndpoint != new.TelegramAPIEndpoint ||
+		old.Transport != new.Transport ||
+		old.Metrics != new.Metrics ||
+		old.LogFormat != new.LogFormat ||
+		!reflect.DeepEqual(old.History, new.History)
+}
+
+// notifyAdmins sends text to the admins from the config and the roster.
+func (d *Dispatcher) notifyAdmins(conf *config.Config, text string) {
+	ids := make(map[int64]bool)
+	for _, id := range conf.AdminChatIDs {
+		ids[id] = true
+	}
+	roster := d.userManager.Roster
+	for _, id := range roster.IDs() {
+		entry, _ := roster.Get(id)
+		if chatID, err := strconv.ParseInt(id, 10, 64); err == nil && entry.Role == "ADMIN" {
+			ids[chatID] = true
+		}
+	}
+
+	for id := range ids {
+		msg := tgbotapi.NewMessage(id, text)
+		msg.ParseMode = tgbotapi.ModeHTML
+		if _, err := d.bot.Send(msg); err != nil {
+			slog.Error("Failed to notify admin", "chat_id", id, "error", err)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+// Package render converts the Markdown produced by models to Telegram HTML.
+package render
+
+import (
+	"html"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+var (
+	headingRe   = regexp.MustCompile(`^#{1,6}\s+(.*)$`)
+	listRe      = regexp.MustCompile(`^(\s*)[-*+]\s+(.*)$`)
+	ruleRe      = regexp.MustCompile(`^\s*([-*_])(\s*[-*_]){2,}\s*$`)
+	codeLangRe  = regexp.MustCompile(`^[A-Za-z0-9_+#.-]+$`)
+	linkURLRe   = regexp.MustCompile(`^https?://[^\s()]+$`)
+	quoteRe     = regexp.MustCompile(`^\s*>\s?(.*)$`)
+	escapableRe = regexp.MustCompile("^[\\\\`*_{}\\[\\]()#+\\-.!~>|]")
+)
+
+// ToHTML converts Markdown to the HTML subset supported by Telegram. Constructs that
+// are not closed yet, as in a partially streamed answer, are kept as literal text,
+// except for code blocks, which are closed at the end of the text. The result is
+// always well-formed.
+func ToHTML(markdown string) string {
+	var out []string
+	var code []string
+	var quote []string
+	inCode := false
+	codeLang := ""
+
+	flushQuote := func() {
+		if len(quote) > 0 {
+			out = append(out, "<blockquote>"+strings.Join(quote, "\n")+"</blockquote>")
+			quote = nil
+		}
+	}
+	flushCode := func() {
+		open := "<pre><code>"
+		if codeLang != "" {
+			open = `<pre><code class="language-` + codeLang + `">`
+		}
+		out = append(out, open+html.EscapeString(strings.Join(code, "\n"))+"</code></pre>")
+		code = nil
+	}
+
+	for _, line := range strings.Split(markdown, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "```") {
+			if inCode {
+				flushCode()
+				inCode = false
+			} else {
+				flushQuote()
+				inCode = true
+				codeLang = strings.TrimSpace(strings.TrimPrefix(trimmed, "```"))
+				if !codeLangRe.MatchString(codeLang) {
+					codeLang = ""
+				}
+			}
+			continue
+		}
+		if inCode {
+			code = append(code, line)
+			continue
+		}
+
+		if m := quoteRe.FindStringSubmatch(line); m != nil {
+			quote = append(quote, renderInline(m[1]))
+			continue
+		}
+		flushQuote()
+
+		switch {
+		case ruleRe.MatchString(line):
+			out = append(out, "──────────")
+		case headingRe.MatchString(line):
+			out = append(out, "<b>"+renderInline(headingRe.FindStringSubmatch(line)[1])+"</b>")
+		case listRe.MatchString(line):
+			m := listRe.FindStringSubmatch(line)
+			out = append(out, m[1]+"• "+renderInline(m[2]))
+		default:
+			out = append(out, renderInline(line))
+		}
+	}
+	flushQuote()
+	if inCode {
+		flushCode()
+	}
+	return strings.Join(out, "\n")
+}
+
+// renderInline converts inline Markdown of a single line.
+func renderInline(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		switch c := s[i]; {
+		case c == '\\' && i+1 < len(s) && escapableRe.MatchString(s[i+1:]):
+			b.WriteString(html.EscapeString(s[i+1 : i+2]))
+			i += 2
+			continue
+		case c == '`':
+			if end := strings.IndexByte(s[i+1:], '`'); end > 0 {
+				b.WriteString("<code>" + html.EscapeString(s[i+1:i+1+end]) + "</code>")
+				i += end + 2
+				continue
+			}
+		case strings.HasPrefix(s[i:], "**") || strings.HasPrefix(s[i:], "__"):
+			if inner, n, ok := delimited(s, i, s[i:i+2]); ok {
+				b.WriteString("<b>" + renderInline(inner) + "</b>")
+				i += n
+				continue
+			}
+		case strings.HasPrefix(s[i:], "~~"):
+			if inner, n, ok := delimited(s, i, "~~"); ok {
+				b.WriteString("<s>" + renderInline(inner) + "</s>")
+				i += n
+				continue
+			}
+		case c == '*' || c == '_':
+			// An underscore inside a word, as in snake_case, is not emphasis
+			if c == '_' && wordBefore(s, i) {
+				break
+			}
+			if inner, n, ok := delimited(s, i, string(c)); ok && (c == '*' || !wordAt(s, i+n)) {
+				b.WriteString("<i>" + renderInline(inner) + "</i>")
+				i += n
+				continue
+			}
+		case c == '[':
+			if text, url, n, ok := link(s[i:]); ok {
+				b.WriteString(`<a href="` + html.EscapeString(url) + `">` + renderInline(text) + "</a>")
+				i += n
+				continue
+			}
+		}
+		b.WriteString(html.EscapeString(s[i : i+1]))
+		i++
+	}
+	return b.String()
+}
+
+// delimited finds the text enclosed by delim starting at s[i]. It returns the inner text
+// and the length of the whole construct. The inner text must not start or end with a space.
+func delimited(s string, i int, delim string) (string, int, bool) {
+	start := i + len(delim)
+	end := strings.Index(s[start:], delim)
+	if end <= 0 {
+		return "", 0, false
+	}
+	inner := s[start : start+end]
+	first, _ := utf8.DecodeRuneInString(inner)
+	last, _ := utf8.DecodeLastRuneInString(inner)
+	if unicode.IsSpace(first) || unicode.IsSpace(last) {
+		return "", 0, false
+	}
+	return inner, len(delim)*2 + end, true
+}
+
+// link parses [text](url) at the start of s.
+func link(s string) (string, string, int, bool) {
+	closeText := strings.Index(s, "](")
+	if closeText <= 1 {
+		return "", "", 0, false
+	}
+	closeURL := strings.IndexByte(s[closeText+2:], ')')
+	if closeURL == -1 {
+		return "", "", 0, false
+	}
+	url := s[closeText+2 : closeText+2+closeURL]
+	if !linkURLRe.MatchString(url) {
+		return "", "", 0, false
+	}
+	return s[1:closeText], url, closeText + 3 + closeURL, true
+}
+
+// wordBefore reports whether the character before s[i] is a letter or digit.
+func wordBefore(s string, i int) bool {
+	r, _ := utf8.DecodeLastRuneInString(s[:i])
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// wordAt reports whether the character starting at s[i] is a letter or digit.
+func wordAt(s string, i int) bool {
+	r, _ := utf8.DecodeRuneInString(s[i:])
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
@@ -0,0 +1,39 @@
+package render
+
+import "testing"
+
+func TestToHTML(t *testing.T) {
+	tests := []struct {
+		name     string
+		markdown string
+		want     string
+	}{
+		{"plain text is escaped", "a < b & c", "a &lt; b &amp; c"},
+		{"bold", "**bold** and __bold__", "<b>bold</b> and <b>bold</b>"},
+		{"italic", "*it* and _it_", "<i>it</i> and <i>it</i>"},
+		{"strikethrough", "~~gone~~", "<s>gone</s>"},
+		{"nested emphasis", "**a *b* c**", "<b>a <i>b</i> c</b>"},
+		{"snake case", "snake_case_name", "snake_case_name"},
+		{"spaced asterisks", "2 * 3 * 4", "2 * 3 * 4"},
+		{"inline code", "run `a<b`", "run <code>a&lt;b</code>"},
+		{"escaped delimiter", `\*not italic\*`, "*not italic*"},
+		{"link", "[site](https://example.com/a?b=1&c=2)", `<a href="https://example.com/a?b=1&amp;c=2">site</a>`},
+		{"unsafe link", "[x](javascript:alert(1))", "[x](javascript:alert(1))"},
+		{"heading", "## Title", "<b>Title</b>"},
+		{"list", "- one\n  * two", "• one\n  • two"},
+		{"rule", "---", "──────────"},
+		{"quote", "> a\n> **b**\nc", "<blockquote>a\n<b>b</b></blockquote>\nc"},
+		{"code block", "```go\nx := a<b\n```", "<pre><code class=\"language-go\">x := a&lt;b</code></pre>"},
+		{"code block without language", "```\n**x**\n```", "<pre><code>**x**</code></pre>"},
+		{"unclosed code block", "```\nx", "<pre><code>x</code></pre>"},
+		{"unclosed bold", "**partial", "**partial"},
+		{"unclosed code", "`partial", "`partial"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ToHTML(tt.markdown); got != tt.want {
+				t.Errorf("ToHTML(%q) = %q, want %q", tt.markdown, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"log/slog"
+	"openrouter-gpt-telegram-bot/api"
+	"time"
+)
+
+// stopGrace is how long stopped answers get to show their notice and be charged.
+const stopGrace = 10 * time.Second
+
+// Shutdown waits for running answers and images to finish. Queued turns do not start,
+// their senders are told the bot is shutting down. Work still running after timeout is
+// stopped: answers keep their partial text with a notice and are charged. It must be
+// called after Run returned.
+func (d *Dispatcher) Shutdown(timeout time.Duration) {
+	d.turns.Close()
+
+	done := make(chan struct{})
+	go func() {
+		d.inflight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return
+	case <-time.After(timeout):
+	}
+
+	slog.Warn("Stopping answers that did not finish in time", "timeout", timeout)
+	d.stop(api.ErrShutdown)
+	select {
+	case <-done:
+	case <-time.After(stopGrace):
+		slog.Error("Answers did not stop in time")
+	}
+}
@@ -0,0 +1,139 @@
+package tokenizer
+
+import (
+	"github.com/pkoukk/tiktoken-go"
+	"github.com/sashabaranov/go-openai"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// tokensPerMessage is the overhead of the role and separators of a chat message.
+	tokensPerMessage = 4
+	// tokensPerReply primes the assistant reply.
+	tokensPerReply = 3
+	// tokensPerImage is the cost of a low detail image, used for every image.
+	tokensPerImage = 85
+	// retryDelay is how long the estimate is used after an encoding failed to load
+	// before loading it is tried again.
+	retryDelay = 5 * time.Minute
+)
+
+var (
+	encodings = make(map[string]*tiktoken.Tiktoken)
+	// loading holds the encodings being downloaded
+	loading = make(map[string]bool)
+	// failed holds when loading an encoding last failed
+	failed = make(map[string]time.Time)
+	mu     sync.Mutex
+	// getEncoding loads an encoding, tests replace it
+	getEncoding = tiktoken.GetEncoding
+)
+
+// encodingName picks the BPE encoding for a model. OpenRouter IDs such as
+// openai/gpt-4o-mini are matched without the vendor prefix, models of other
+// vendors get cl100k_base, which is close enough for budgeting.
+func encodingName(model string) string {
+	if i := strings.LastIndex(model, "/"); i != -1 {
+		model = model[i+1:]
+	}
+	if name, ok := tiktoken.MODEL_TO_ENCODING[model]; ok {
+		return name
+	}
+	for prefix, name := range tiktoken.MODEL_PREFIX_TO_ENCODING {
+		if strings.HasPrefix(model, prefix) {
+			return name
+		}
+	}
+	if strings.HasPrefix(model, "o1") || strings.HasPrefix(model, "o3") || strings.HasPrefix(model, "gpt-4.1") {
+		return tiktoken.MODEL_O200K_BASE
+	}
+	return tiktoken.MODEL_CL100K_BASE
+}
+
+// encoding returns the encoding for a model, or nil while it is not available.
+// The BPE ranks are downloaded on first use (and cached in TIKTOKEN_CACHE_DIR),
+// so loading happens in the background and callers fall back to an estimate.
+func encoding(model string) *tiktoken.Tiktoken {
+	name := encodingName(model)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if enc, ok := encodings[name]; ok {
+		return enc
+	}
+	if loading[name] || time.Since(failed[name]) < retryDelay {
+		return nil
+	}
+	loading[name] = true
+	go load(name)
+	return nil
+}
+
+func load(name string) {
+	enc, err := getEncoding(name)
+
+	mu.Lock()
+	defer mu.Unlock()
+	delete(loading, name)
+	if err != nil {
+		// The download may work later, until then the estimate is used
+		failed[name] = time.Now()
+		slog.Warn("Failed to load encoding, estimating token counts", "encoding", name, "retry_in", retryDelay, "error", err)
+		return
+	}
+	delete(failed, name)
+	encodings[name] = enc
+}
+
+// Preload starts loading the encoding for a model so it is ready for the first message.
+func Preload(model string) {
+	encoding(model)
+}
+
+// Count returns the number of tokens in text for a model.
+func Count(model, text string) int {
+	if enc := encoding(model); enc != nil {
+		return len(enc.EncodeOrdinary(text))
+	}
+	return estimate(text)
+}
+
+// estimate approximates the token count as one token per four ASCII bytes, which holds
+// for English, and one token per other character. Other scripts mostly take less than a
+// token per character and CJK about one, so the estimate errs on the high side except
+// for rare characters that are split in several tokens.
+func estimate(text string) int {
+	ascii, other := 0, 0
+	for i := 0; i < len(text); {
+		if text[i] < utf8.RuneSelf {
+			ascii++
+			i++
+			continue
+		}
+		_, size := utf8.DecodeRuneInString(text[i:])
+		other++
+		i += size
+	}
+	return (ascii+3)/4 + other
+}
+
+// CountMessages returns the number of prompt tokens a chat completion request with
+// these messages uses, including the per-message overhead.
+func CountMessages(model string, messages []openai.ChatCompletionMessage) int {
+	total := tokensPerReply
+	for _, m := range messages {
+		total += tokensPerMessage + Count(model, m.Role) + Count(model, m.Content)
+		for _, part := range m.MultiContent {
+			if part.Type == openai.ChatMessagePartTypeImageURL {
+				total += tokensPerImage
+			} else {
+				total += Count(model, part.Text)
+			}
+		}
+	}
+	return total
+}
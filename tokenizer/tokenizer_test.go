@@ -0,0 +1,153 @@
+package tokenizer
+
+import (
+	"errors"
+	"github.com/pkoukk/tiktoken-go"
+	"github.com/sashabaranov/go-openai"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestEncodingName(t *testing.T) {
+	tests := []struct {
+		model string
+		want  string
+	}{
+		{"gpt-4", tiktoken.MODEL_CL100K_BASE},
+		{"openai/gpt-4o-mini", tiktoken.MODEL_O200K_BASE},
+		{"openai/o3-mini", tiktoken.MODEL_O200K_BASE},
+		{"openai/gpt-4.1-nano", tiktoken.MODEL_O200K_BASE},
+		{"anthropic/claude-3.5-sonnet", tiktoken.MODEL_CL100K_BASE},
+		{"mistral", tiktoken.MODEL_CL100K_BASE},
+	}
+	for _, tt := range tests {
+		t.Run(tt.model, func(t *testing.T) {
+			if got := encodingName(tt.model); got != tt.want {
+				t.Errorf("encodingName(%q) = %q, want %q", tt.model, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEstimate(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want int
+	}{
+		{"empty", "", 0},
+		{"one byte", "a", 1},
+		{"four bytes", "abcd", 1},
+		{"five bytes", "abcde", 2},
+		{"cyrillic", "привет", 6},
+		{"cjk", "你好世界", 4},
+		{"mixed", "hi 你好", 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := estimate(tt.text); got != tt.want {
+				t.Errorf("estimate(%q) = %d, want %d", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+// withoutEncoding makes Count use the estimate for model, as when its encoding cannot be loaded.
+func withoutEncoding(t *testing.T, model string) {
+	name := encodingName(model)
+	mu.Lock()
+	defer mu.Unlock()
+	enc, hadEncoding := encodings[name]
+	wasLoading := loading[name]
+	delete(encodings, name)
+	loading[name] = true
+	t.Cleanup(func() {
+		mu.Lock()
+		defer mu.Unlock()
+		if hadEncoding {
+			encodings[name] = enc
+		}
+		loading[name] = wasLoading
+	})
+}
+
+func TestCountMessages(t *testing.T) {
+	const model = "test-model"
+	withoutEncoding(t, model)
+
+	tests := []struct {
+		name     string
+		messages []openai.ChatCompletionMessage
+		want     int
+	}{
+		{"no messages", nil, tokensPerReply},
+		{
+			name:     "text message",
+			messages: []openai.ChatCompletionMessage{{Role: "user", Content: "abcdefgh"}},
+			want:     tokensPerReply + tokensPerMessage + estimate("user") + 2,
+		},
+		{
+			name: "image message",
+			messages: []openai.ChatCompletionMessage{{Role: "user", MultiContent: []openai.ChatMessagePart{
+				{Type: openai.ChatMessagePartTypeText, Text: "abcd"},
+				{Type: openai.ChatMessagePartTypeImageURL},
+			}}},
+			want: tokensPerReply + tokensPerMessage + estimate("user") + 1 + tokensPerImage,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CountMessages(model, tt.messages); got != tt.want {
+				t.Errorf("CountMessages() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEncodingRetriesFailedLoad(t *testing.T) {
+	const model = "retry-model"
+	name := encodingName(model)
+	var calls atomic.Int32
+	getEncoding = func(string) (*tiktoken.Tiktoken, error) {
+		calls.Add(1)
+		return nil, errors.New("offline")
+	}
+	t.Cleanup(func() {
+		getEncoding = tiktoken.GetEncoding
+		mu.Lock()
+		defer mu.Unlock()
+		delete(failed, name)
+	})
+	loaded := func() {
+		for {
+			mu.Lock()
+			done := !loading[name]
+			mu.Unlock()
+			if done {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	if enc := encoding(model); enc != nil {
+		t.Fatal("encoding available before it was loaded")
+	}
+	loaded()
+	// A failed load is not tried again right away
+	encoding(model)
+	loaded()
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("loaded %d times within the retry delay, want 1", n)
+	}
+
+	mu.Lock()
+	failed[name] = time.Now().Add(-retryDelay)
+	mu.Unlock()
+	encoding(model)
+	loaded()
+	if n := calls.Load(); n != 2 {
+		t.Fatalf("loaded %d times after the retry delay, want 2", n)
+	}
+}
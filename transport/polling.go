@@ -0,0 +1,127 @@
+package transport
+
+import (
+	"encoding/json"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Polling receives updates with getUpdates long polling.
+type Polling struct {
+	bot      *tgbotapi.BotAPI
+	timeout  int
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+func NewPolling(bot *tgbotapi.BotAPI) *Polling {
+	return &Polling{
+		bot:     bot,
+		timeout: 60,
+		stop:    make(chan struct{}),
+	}
+}
+
+func (p *Polling) Start() (<-chan Update, error) {
+	// getUpdates does not work while a webhook is set
+	_, err := p.bot.Request(tgbotapi.DeleteWebhookConfig{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete webhook: %w", err)
+	}
+
+	updates := make(chan Update, p.bot.Buffer)
+	go func() {
+		defer close(updates)
+		offset := 0
+		for {
+			select {
+			case <-p.stop:
+				return
+			default:
+			}
+
+			batch, next, err := p.getUpdates(offset)
+			if err != nil {
+				slog.Error("Failed to get updates, retrying in 3 seconds", "error", err)
+				time.Sleep(3 * time.Second)
+				continue
+			}
+
+			for _, update := range batch {
+				if update.UpdateID < offset {
+					continue
+				}
+				offset = update.UpdateID + 1
+				select {
+				case updates <- update:
+				case <-p.stop:
+					return
+				}
+			}
+			// Updates that could not be decoded are confirmed too, so they are not fetched again
+			if next > offset {
+				offset = next
+			}
+		}
+	}()
+
+	return updates, nil
+}
+
+// getUpdates requests updates directly instead of through BotAPI.GetUpdates,
+// which would drop the fields decoded by decodeUpdate. It also returns the offset
+// that confirms the whole batch.
+func (p *Polling) getUpdates(offset int) ([]Update, int, error) {
+	params := tgbotapi.Params{}
+	params.AddNonZero("offset", offset)
+	params.AddNonZero("timeout", p.timeout)
+
+	resp, err := p.bot.MakeRequest("getUpdates", params)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var raw []json.RawMessage
+	if err := json.Unmarshal(resp.Result, &raw); err != nil {
+		return nil, 0, err
+	}
+	updates, next := decodeBatch(raw)
+	return updates, next, nil
+}
+
+// decodeBatch decodes the updates of a getUpdates response. An update that cannot be
+// decoded is logged and skipped without losing the rest of the batch. It returns the
+// decoded updates and the offset after the highest update ID of the batch.
+func decodeBatch(raw []json.RawMessage) ([]Update, int) {
+	updates := make([]Update, 0, len(raw))
+	next := 0
+	for _, data := range raw {
+		var header struct {
+			UpdateID int `json:"update_id"`
+		}
+		if err := json.Unmarshal(data, &header); err == nil && header.UpdateID >= next {
+			next = header.UpdateID + 1
+		}
+		update, err := decodeUpdate(data)
+		if err != nil {
+			slog.Error("Skipping update that cannot be decoded", "update_id", header.UpdateID, "error", err)
+			continue
+		}
+		updates = append(updates, update)
+	}
+	return updates, next
+}
+
+// Err returns nil, polling retries failed requests until it is stopped.
+func (p *Polling) Err() error {
+	return nil
+}
+
+func (p *Polling) Stop() {
+	p.stopOnce.Do(func() {
+		close(p.stop)
+	})
+}
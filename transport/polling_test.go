@@ -0,0 +1,27 @@
+package transport
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestDecodeBatchSkipsMalformedUpdates(t *testing.T) {
+	raw := []json.RawMessage{
+		json.RawMessage(testUpdate),
+		json.RawMessage(`{"update_id":8,"message":{"message_id":"three"}}`),
+		json.RawMessage(`{"update_id":9,"message":{"message_id":4,"chat":{"id":42,"type":"private"},"text":"there"}}`),
+		json.RawMessage(`{"update_id":10,"message":{"message_id":"five"}}`),
+	}
+	updates, next := decodeBatch(raw)
+
+	var ids []int
+	for _, update := range updates {
+		ids = append(ids, update.UpdateID)
+	}
+	if len(ids) != 2 || ids[0] != 7 || ids[1] != 9 {
+		t.Errorf("decoded updates %v, want [7 9]", ids)
+	}
+	if next != 11 {
+		t.Errorf("next offset = %d, want 11 after the malformed last update", next)
+	}
+}
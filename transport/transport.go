@@ -0,0 +1,78 @@
+package transport
+
+import (
+	"encoding/json"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"openrouter-gpt-telegram-bot/config"
+)
+
+// Transport delivers Telegram updates to the dispatcher regardless of how they are received.
+type Transport interface {
+	// Start begins receiving updates and returns the channel they are delivered on.
+	Start() (<-chan Update, error)
+	// Stop stops receiving updates and closes the channel returned by Start.
+	Stop()
+	// Err returns the error that closed the channel returned by Start, or nil if it
+	// was closed by Stop.
+	Err() error
+}
+
+// Update is a Telegram update together with the fields the bot API library does not decode.
+type Update struct {
+	tgbotapi.Update
+	// MessageThreadID is the forum topic of the message, zero outside forum topics.
+	MessageThreadID int
+}
+
+// topicMessage holds the forum topic fields of a message.
+type topicMessage struct {
+	MessageThreadID int  `json:"message_thread_id"`
+	IsTopicMessage  bool `json:"is_topic_message"`
+}
+
+// topicFields holds the forum topic fields of the messages an update can carry.
+type topicFields struct {
+	Message       *topicMessage `json:"message"`
+	EditedMessage *topicMessage `json:"edited_message"`
+	CallbackQuery *struct {
+		Message *topicMessage `json:"message"`
+	} `json:"callback_query"`
+}
+
+// decodeUpdate decodes a raw update as sent by Telegram.
+func decodeUpdate(data []byte) (Update, error) {
+	var update Update
+	if err := json.Unmarshal(data, &update.Update); err != nil {
+		return update, err
+	}
+	var topic topicFields
+	if err := json.Unmarshal(data, &topic); err != nil {
+		return update, err
+	}
+
+	message := topic.Message
+	if message == nil {
+		message = topic.EditedMessage
+	}
+	if message == nil && topic.CallbackQuery != nil {
+		message = topic.CallbackQuery.Message
+	}
+	// message_thread_id is also set for reply threads in ordinary supergroups
+	if message != nil && message.IsTopicMessage {
+		update.MessageThreadID = message.MessageThreadID
+	}
+	return update, nil
+}
+
+// New returns the transport selected by conf.Transport.Mode.
+func New(bot *tgbotapi.BotAPI, conf *config.Config) (Transport, error) {
+	switch conf.Transport.Mode {
+	case "polling":
+		return NewPolling(bot), nil
+	case "webhook":
+		return NewWebhook(bot, conf.Transport), nil
+	default:
+		return nil, fmt.Errorf("unknown transport mode: %s", conf.Transport.Mode)
+	}
+}
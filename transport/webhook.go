@@ -0,0 +1,164 @@
+package transport
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"io"
+	"log/slog"
+	"net/http"
+	"openrouter-gpt-telegram-bot/config"
+	"sync"
+	"time"
+)
+
+// secretTokenHeader is the header Telegram fills with the secret_token passed to setWebhook.
+const secretTokenHeader = "X-Telegram-Bot-Api-Secret-Token"
+
+// Webhook receives updates through a built-in HTTP listener. It serves TLS itself
+// when a certificate is configured, otherwise it expects a reverse proxy in front.
+type Webhook struct {
+	bot     *tgbotapi.BotAPI
+	params  config.TransportParameters
+	server  *http.Server
+	updates chan Update
+	// done stops handlers waiting to deliver an update
+	done chan struct{}
+	// mu is held for reading while a handler may send on updates, closed is set
+	// once updates is closed
+	mu       sync.RWMutex
+	closed   bool
+	err      error
+	stopOnce sync.Once
+}
+
+func NewWebhook(bot *tgbotapi.BotAPI, params config.TransportParameters) *Webhook {
+	return &Webhook{
+		bot:     bot,
+		params:  params,
+		updates: make(chan Update, bot.Buffer),
+		done:    make(chan struct{}),
+	}
+}
+
+func (w *Webhook) Start() (<-chan Update, error) {
+	mux := http.NewServeMux()
+	mux.Handle(w.params.Path, w)
+	w.server = &http.Server{
+		Addr:              w.params.ListenAddr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		var err error
+		if w.params.TLSCertFile != "" {
+			slog.Info("Webhook listening", "addr", w.params.ListenAddr, "path", w.params.Path, "tls", true)
+			err = w.server.ListenAndServeTLS(w.params.TLSCertFile, w.params.TLSKeyFile)
+		} else {
+			slog.Info("Webhook listening", "addr", w.params.ListenAddr, "path", w.params.Path, "tls", false)
+			err = w.server.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			// Closing the updates channel ends the dispatcher, which reports Err
+			w.mu.Lock()
+			w.err = fmt.Errorf("webhook server failed: %w", err)
+			w.mu.Unlock()
+			w.Stop()
+		}
+	}()
+
+	if err := w.setWebhook(); err != nil {
+		w.server.Close()
+		return nil, err
+	}
+
+	return w.updates, nil
+}
+
+// setWebhook registers the webhook URL with Telegram. WebhookConfig in the library
+// has no secret_token field, so the request parameters are built by hand.
+func (w *Webhook) setWebhook() error {
+	params := tgbotapi.Params{}
+	params["url"] = w.params.WebhookURL
+	params.AddNonEmpty("secret_token", w.params.SecretToken)
+
+	_, err := w.bot.MakeRequest("setWebhook", params)
+	if err != nil {
+		return fmt.Errorf("failed to set webhook: %w", err)
+	}
+	return nil
+}
+
+// ServeHTTP verifies the secret token and forwards the decoded update to the updates channel.
+func (w *Webhook) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if w.params.SecretToken != "" {
+		token := r.Header.Get(secretTokenHeader)
+		if subtle.ConstantTimeCompare([]byte(token), []byte(w.params.SecretToken)) != 1 {
+			slog.Warn("Webhook request rejected: invalid secret token", "remote_addr", r.RemoteAddr)
+			http.Error(rw, "forbidden", http.StatusForbidden)
+			return
+		}
+	}
+
+	data, err := io.ReadAll(http.MaxBytesReader(rw, r.Body, 1<<20))
+	if err != nil {
+		http.Error(rw, "bad request", http.StatusBadRequest)
+		return
+	}
+	update, err := decodeUpdate(data)
+	if err != nil {
+		slog.Error("Failed to decode webhook update", "error", err)
+		http.Error(rw, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.closed {
+		http.Error(rw, "shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	select {
+	case w.updates <- update:
+		rw.WriteHeader(http.StatusOK)
+	case <-w.done:
+		// Telegram will redeliver the update
+		http.Error(rw, "shutting down", http.StatusServiceUnavailable)
+	case <-r.Context().Done():
+		// Telegram will redeliver the update
+		http.Error(rw, "timeout", http.StatusServiceUnavailable)
+	}
+}
+
+func (w *Webhook) Err() error {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.err
+}
+
+func (w *Webhook) Stop() {
+	w.stopOnce.Do(func() {
+		// Handlers stop waiting for the dispatcher before the server waits for them
+		close(w.done)
+		if w.server != nil {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := w.server.Shutdown(ctx); err != nil {
+				slog.Error("Webhook server shutdown failed", "error", err)
+			}
+		}
+		// Handlers still running after the shutdown timeout hold mu, so none of
+		// them can send on the closed channel
+		w.mu.Lock()
+		w.closed = true
+		close(w.updates)
+		w.mu.Unlock()
+	})
+}
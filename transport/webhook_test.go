@@ -0,0 +1,119 @@
+package transport
+
+import (
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"net/http"
+	"net/http/httptest"
+	"openrouter-gpt-telegram-bot/config"
+	"strings"
+	"testing"
+)
+
+const testUpdate = `{"update_id":7,"message":{"message_id":3,"chat":{"id":42,"type":"private"},"text":"hi"}}`
+
+func newTestWebhook(secret string) *Webhook {
+	return NewWebhook(&tgbotapi.BotAPI{Buffer: 1}, config.TransportParameters{SecretToken: secret})
+}
+
+func TestWebhookSecretToken(t *testing.T) {
+	tests := []struct {
+		name   string
+		secret string
+		header string
+		status int
+	}{
+		{"no secret configured", "", "", http.StatusOK},
+		{"matching token", "s3cret", "s3cret", http.StatusOK},
+		{"missing token", "s3cret", "", http.StatusForbidden},
+		{"wrong token", "s3cret", "guess", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newTestWebhook(tt.secret)
+			server := httptest.NewServer(w)
+			defer server.Close()
+
+			request, err := http.NewRequest(http.MethodPost, server.URL, strings.NewReader(testUpdate))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.header != "" {
+				request.Header.Set(secretTokenHeader, tt.header)
+			}
+			response, err := http.DefaultClient.Do(request)
+			if err != nil {
+				t.Fatal(err)
+			}
+			response.Body.Close()
+			if response.StatusCode != tt.status {
+				t.Fatalf("status = %d, want %d", response.StatusCode, tt.status)
+			}
+			if tt.status != http.StatusOK && len(w.updates) != 0 {
+				t.Fatal("rejected update was dispatched")
+			}
+		})
+	}
+}
+
+func TestWebhookDispatch(t *testing.T) {
+	w := newTestWebhook("")
+	server := httptest.NewServer(w)
+	defer server.Close()
+
+	response, err := http.Post(server.URL, "application/json", strings.NewReader(testUpdate))
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want %d", response.StatusCode, http.StatusOK)
+	}
+
+	select {
+	case update := <-w.updates:
+		if update.UpdateID != 7 || update.Message == nil || update.Message.Text != "hi" {
+			t.Fatalf("unexpected update: %+v", update.Update)
+		}
+	default:
+		t.Fatal("update was not dispatched")
+	}
+}
+
+func TestWebhookRejects(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		body   string
+		status int
+	}{
+		{"get request", http.MethodGet, "", http.StatusMethodNotAllowed},
+		{"malformed body", http.MethodPost, "{", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newTestWebhook("")
+			recorder := httptest.NewRecorder()
+			w.ServeHTTP(recorder, httptest.NewRequest(tt.method, "/", strings.NewReader(tt.body)))
+			if recorder.Code != tt.status {
+				t.Fatalf("status = %d, want %d", recorder.Code, tt.status)
+			}
+		})
+	}
+}
+
+func TestWebhookAfterStop(t *testing.T) {
+	w := newTestWebhook("")
+	w.Stop()
+	if _, ok := <-w.updates; ok {
+		t.Fatal("updates channel is still open after Stop")
+	}
+
+	recorder := httptest.NewRecorder()
+	w.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(testUpdate)))
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want %d", recorder.Code, http.StatusServiceUnavailable)
+	}
+	if err := w.Err(); err != nil {
+		t.Fatalf("Err() = %v after Stop", err)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"errors"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"openrouter-gpt-telegram-bot/lang"
+	"openrouter-gpt-telegram-bot/logging"
+	"openrouter-gpt-telegram-bot/user"
+	"sync"
+)
+
+// maxQueuedTurns caps the turns waiting in one conversation with the queue policy.
+const maxQueuedTurns = 10
+
+var (
+	// errBusy is returned for a turn turned away by the message policy.
+	errBusy = errors.New("conversation is busy")
+	// errClosed is returned for a turn submitted after the queue was closed.
+	errClosed = errors.New("turn queue is closed")
+	// errReplaced is the reason a waiting turn is dropped for a newer one with the cancel policy.
+	errReplaced = errors.New("replaced by a newer turn")
+)
+
+// submitTurn runs work on a conversation after the turns before it, following the
+// configured policy, and tells the sender when the message is turned away, replaced by
+// a newer one or dropped on shutdown.
+func (d *Dispatcher) submitTurn(ctx context.Context, message *tgbotapi.Message, conversation *user.UsageTracker, run func(ctx context.Context)) {
+	conf := d.conf()
+	dropNotice := func(reason error) {
+		if errors.Is(reason, errReplaced) {
+			logging.From(ctx).Info("Message replaced by a newer one")
+			d.bot.Send(newReply(message, lang.Translate("queue.replaced", conf.Lang)))
+			return
+		}
+		logging.From(ctx).Info("Message dropped on shutdown")
+		d.bot.Send(newReply(message, lang.Translate("answer.shutdown", conf.Lang)))
+	}
+	switch err := d.turns.Submit(ctx, conversation.UserID, conf.MessagePolicy, run, dropNotice); {
+	case errors.Is(err, errClosed):
+		dropNotice(err)
+	case err != nil:
+		logging.From(ctx).Info("Message rejected while an answer is running", "policy", conf.MessagePolicy)
+		d.bot.Send(newReply(message, lang.Translate("queue.busy", conf.Lang)))
+	}
+}
+
+// turnQueue runs the turns of each conversation one after another, so answers are
+// added to the history in order and never stream into the same conversation at once.
+type turnQueue struct {
+	conversations map[string]*conversationTurns
+	mu            sync.Mutex
+	// running counts the turns that have started and not finished
+	running *sync.WaitGroup
+	// closed is set on shutdown, no turns start after it
+	closed bool
+}
+
+type conversationTurns struct {
+	pending []turn
+	// cancel stops the running turn
+	cancel context.CancelFunc
+}
+
+type turn struct {
+	ctx context.Context
+	run func(ctx context.Context)
+	// dropped is called instead of run with the reason the turn does not start:
+	// errClosed or errReplaced
+	dropped func(reason error)
+}
+
+func newTurnQueue(running *sync.WaitGroup) *turnQueue {
+	return &turnQueue{conversations: make(map[string]*conversationTurns), running: running}
+}
+
+// Submit runs a turn of a conversation once the turns before it are done. The policy
+// decides what happens while a turn is running: queue waits for it, cancel stops it
+// and the queued turns in favor of the new one, reject drops the new turn.
+// It returns errBusy if the turn is turned away and errClosed after Close. If the turn
+// does not start after waiting, dropped is called instead of run.
+func (q *turnQueue) Submit(ctx context.Context, key, policy string, run func(ctx context.Context), dropped func(reason error)) error {
+	replaced, err := q.submit(key, policy, turn{ctx: ctx, run: run, dropped: dropped})
+	// Senders are told outside the lock
+	for _, t := range replaced {
+		t.dropped(errReplaced)
+	}
+	return err
+}
+
+// submit adds next to the turns of a conversation and returns the waiting turns it replaces.
+func (q *turnQueue) submit(key, policy string, next turn) ([]turn, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return nil, errClosed
+	}
+
+	conversation, busy := q.conversations[key]
+	if !busy {
+		conversation = &conversationTurns{}
+		q.conversations[key] = conversation
+		q.start(key, conversation, next)
+		return nil, nil
+	}
+
+	switch policy {
+	case "reject":
+		return nil, errBusy
+	case "cancel":
+		replaced := conversation.pending
+		conversation.pending = []turn{next}
+		conversation.cancel()
+		return replaced, nil
+	default:
+		if len(conversation.pending) >= maxQueuedTurns {
+			return nil, errBusy
+		}
+		conversation.pending = append(conversation.pending, next)
+		return nil, nil
+	}
+}
+
+// Close stops starting turns. Running turns go on, the queued ones are dropped.
+func (q *turnQueue) Close() {
+	q.mu.Lock()
+	q.closed = true
+	var dropped []turn
+	for _, conversation := range q.conversations {
+		dropped = append(dropped, conversation.pending...)
+		conversation.pending = nil
+	}
+	q.mu.Unlock()
+
+	for _, t := range dropped {
+		t.dropped(errClosed)
+	}
+}
+
+// start runs a turn and then the turns queued behind it. q.mu must be held.
+func (q *turnQueue) start(key string, conversation *conversationTurns, t turn) {
+	ctx, cancel := context.WithCancel(t.ctx)
+	conversation.cancel = cancel
+	q.running.Add(1)
+	go func() {
+		// The next turn is counted before this one is done, so waiting
+		// for running also waits for the queued turns
+		defer q.running.Done()
+		t.run(ctx)
+		cancel()
+
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		if len(conversation.pending) == 0 {
+			delete(q.conversations, key)
+			return
+		}
+		next := conversation.pending[0]
+		conversation.pending = conversation.pending[1:]
+		q.start(key, conversation, next)
+	}()
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestTurnQueueClose(t *testing.T) {
+	var running sync.WaitGroup
+	q := newTurnQueue(&running)
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	ran := make(chan string, 3)
+	var dropped []string
+	var mu sync.Mutex
+	submit := func(name string, run func(ctx context.Context)) error {
+		return q.Submit(context.Background(), "chat", "queue", func(ctx context.Context) {
+			ran <- name
+			if run != nil {
+				run(ctx)
+			}
+		}, func(error) {
+			mu.Lock()
+			defer mu.Unlock()
+			dropped = append(dropped, name)
+		})
+	}
+
+	if err := submit("first", func(context.Context) {
+		close(started)
+		<-release
+	}); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	if err := submit("queued", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	q.Close()
+	if err := submit("late", nil); !errors.Is(err, errClosed) {
+		t.Fatalf("Submit after Close = %v, want %v", err, errClosed)
+	}
+	close(release)
+	running.Wait()
+	close(ran)
+
+	var names []string
+	for name := range ran {
+		names = append(names, name)
+	}
+	if len(names) != 1 || names[0] != "first" {
+		t.Errorf("ran %v, want only the running turn", names)
+	}
+	if len(dropped) != 1 || dropped[0] != "queued" {
+		t.Errorf("dropped %v, want the queued turn", dropped)
+	}
+}
+
+func TestTurnQueueCancelNotifiesReplacedTurns(t *testing.T) {
+	var running sync.WaitGroup
+	q := newTurnQueue(&running)
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	ran := make(chan string, 3)
+	dropped := make(chan string, 3)
+	submit := func(name string, run func(ctx context.Context)) error {
+		return q.Submit(context.Background(), "chat", "cancel", func(ctx context.Context) {
+			ran <- name
+			if run != nil {
+				run(ctx)
+			}
+		}, func(reason error) {
+			if !errors.Is(reason, errReplaced) {
+				t.Errorf("%s dropped with %v, want %v", name, reason, errReplaced)
+			}
+			dropped <- name
+		})
+	}
+
+	// The running turn ignores the cancellation, so the next turns wait for it
+	if err := submit("first", func(context.Context) {
+		close(started)
+		<-release
+	}); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	for _, name := range []string{"second", "last"} {
+		if err := submit(name, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(release)
+	running.Wait()
+	close(ran)
+	close(dropped)
+
+	var names []string
+	for name := range ran {
+		names = append(names, name)
+	}
+	if len(names) != 2 || names[0] != "first" || names[1] != "last" {
+		t.Errorf("ran %v, want [first last]", names)
+	}
+	var droppedNames []string
+	for name := range dropped {
+		droppedNames = append(droppedNames, name)
+	}
+	if len(droppedNames) != 1 || droppedNames[0] != "second" {
+		t.Errorf("dropped %v, want the replaced turn", droppedNames)
+	}
+}
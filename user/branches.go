@@ -0,0 +1,91 @@
+package user
+
+import "slices"
+
+// maxBranches caps the branches kept per conversation, the oldest are dropped first.
+const maxBranches = 20
+
+// ForkAt makes the answer shown by messageID the end of the active branch, so the next
+// question continues the conversation from there. The turns after it are kept as a
+// branch that replying to one of their answers switches back to. It reports whether
+// the active branch changed; replies to the latest answer or to answers that are no
+// longer known leave it as it is.
+func (ut *UsageTracker) ForkAt(messageID int) bool {
+	ut.History.mu.Lock()
+	defer ut.History.mu.Unlock()
+
+	messages := ut.History.messages
+	if i := answerIndex(messages, messageID); i >= 0 {
+		if i == len(messages)-1 {
+			return false
+		}
+		ut.archiveBranch(messages)
+		ut.History.messages = append([]Message(nil), messages[:i+1]...)
+		ut.saveHistory()
+		return true
+	}
+
+	for _, branch := range ut.History.branches {
+		if i := answerIndex(branch, messageID); i >= 0 {
+			path := append([]Message(nil), branch[:i+1]...)
+			ut.archiveBranch(messages)
+			ut.History.messages = path
+			ut.saveHistory()
+			return true
+		}
+	}
+	return false
+}
+
+// BranchCount returns the number of branches besides the active one.
+func (ut *UsageTracker) BranchCount() int {
+	ut.History.mu.Lock()
+	defer ut.History.mu.Unlock()
+	return len(ut.History.branches)
+}
+
+// archiveBranch keeps path as a branch. Branches that are a prefix of another one
+// hold no turns of their own and are not kept. History.mu must be held.
+func (ut *UsageTracker) archiveBranch(path []Message) {
+	if len(path) == 0 {
+		return
+	}
+	branches := ut.History.branches[:0:0]
+	for _, branch := range ut.History.branches {
+		if isPrefix(path, branch) {
+			// path is already part of a kept branch
+			return
+		}
+		if !isPrefix(branch, path) {
+			branches = append(branches, branch)
+		}
+	}
+	branches = append(branches, append([]Message(nil), path...))
+	if len(branches) > maxBranches {
+		branches = branches[len(branches)-maxBranches:]
+	}
+	ut.History.branches = branches
+}
+
+// answerIndex returns the index of the answer shown by messageID in messages, or -1.
+func answerIndex(messages []Message, messageID int) int {
+	for i, msg := range messages {
+		if msg.Role == "assistant" && slices.Contains(msg.MessageIDs, messageID) {
+			return i
+		}
+	}
+	return -1
+}
+
+// isPrefix reports whether a is a prefix of b.
+func isPrefix(a, b []Message) bool {
+	if len(a) > len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Role != b[i].Role || a[i].Content != b[i].Content || !slices.Equal(a[i].MessageIDs, b[i].MessageIDs) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,39 @@
+package user
+
+import "context"
+
+type generation struct {
+	cancel context.CancelCauseFunc
+}
+
+// StartGeneration returns a context for generating an answer in the conversation,
+// which StopGeneration cancels. done must be called when the answer is finished.
+func (ut *UsageTracker) StartGeneration(ctx context.Context) (genCtx context.Context, done func()) {
+	genCtx, cancel := context.WithCancelCause(ctx)
+	g := &generation{cancel: cancel}
+
+	ut.generationMu.Lock()
+	ut.generation = g
+	ut.generationMu.Unlock()
+
+	return genCtx, func() {
+		ut.generationMu.Lock()
+		if ut.generation == g {
+			ut.generation = nil
+		}
+		ut.generationMu.Unlock()
+		cancel(nil)
+	}
+}
+
+// StopGeneration cancels the answer being generated with cause and reports whether one was running.
+func (ut *UsageTracker) StopGeneration(cause error) bool {
+	ut.generationMu.Lock()
+	defer ut.generationMu.Unlock()
+	if ut.generation == nil {
+		return false
+	}
+	ut.generation.cancel(cause)
+	ut.generation = nil
+	return true
+}
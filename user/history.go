@@ -1,23 +1,39 @@
 package user
 
-import "time"
+import (
+	"log/slog"
+	"openrouter-gpt-telegram-bot/config"
+	"openrouter-gpt-telegram-bot/document"
+	"time"
+)
+
+// SummaryPrefix starts the message that replaces dropped turns with their summary.
+const SummaryPrefix = "Summary of the earlier conversation: "
+
+// DefaultModel is stored as the picked model when the configured default model is picked,
+// it follows the config and takes precedence over the model of a persona.
+const DefaultModel = "default"
 
 func (ut *UsageTracker) AddMessage(role, content string) {
 	ut.History.mu.Lock()
 	defer ut.History.mu.Unlock()
 	ut.History.messages = append(ut.History.messages, Message{Role: role, Content: content})
+	ut.saveHistory()
 }
 
 func (ut *UsageTracker) GetMessages() []Message {
 	ut.History.mu.Lock()
 	defer ut.History.mu.Unlock()
-	return ut.History.messages
+	return append([]Message(nil), ut.History.messages...)
 }
 
 func (ut *UsageTracker) ClearHistory() {
 	ut.History.mu.Lock()
 	defer ut.History.mu.Unlock()
 	ut.History.messages = []Message{}
+	ut.History.branches = nil
+	ut.History.documents = nil
+	ut.saveHistory()
 }
 
 func (ut *UsageTracker) CheckHistory(maxMessages int, maxTime int) {
@@ -27,13 +43,332 @@ func (ut *UsageTracker) CheckHistory(maxMessages int, maxTime int) {
 	if ut.LastMessageTime.IsZero() {
 		ut.LastMessageTime = time.Now()
 	}
-	if ut.LastMessageTime.Before(time.Now().Add(-time.Duration(maxTime) * time.Minute)) {
+	changed := false
+	if ut.LastMessageTime.Before(time.Now().Add(-time.Duration(maxTime)*time.Minute)) && len(ut.History.messages) > 0 {
 		// Remove messages older than the maximum time limit
 		ut.History.messages = make([]Message, 0)
+		ut.History.branches = nil
+		changed = true
 	}
 
 	if len(ut.History.messages) > maxMessages {
 		// Удаляем первые сообщения, чтобы оставить только последние maxMessages
 		ut.History.messages = ut.History.messages[len(ut.History.messages)-maxMessages:]
+		changed = true
+	}
+	if changed {
+		ut.saveHistory()
+	}
+}
+
+// Touch records now as the time of the latest message, from which CheckHistory
+// measures the age of the history.
+func (ut *UsageTracker) Touch(now time.Time) {
+	ut.History.mu.Lock()
+	defer ut.History.mu.Unlock()
+	ut.LastMessageTime = now
+}
+
+// TrimHistory drops the oldest messages until fits reports that the history fits,
+// and returns the dropped messages. The history never starts with an assistant
+// message, so whole turns are dropped.
+func (ut *UsageTracker) TrimHistory(fits func([]Message) bool) []Message {
+	ut.History.mu.Lock()
+	defer ut.History.mu.Unlock()
+
+	messages := ut.History.messages
+	dropped := 0
+	for dropped < len(messages) && !fits(messages[dropped:]) {
+		dropped++
+		for dropped < len(messages) && messages[dropped].Role == "assistant" {
+			dropped++
+		}
+	}
+	if dropped == 0 {
+		return nil
+	}
+
+	removed := append([]Message(nil), messages[:dropped]...)
+	ut.History.messages = append([]Message(nil), messages[dropped:]...)
+	ut.saveHistory()
+	return removed
+}
+
+// AddSummary puts a summary of dropped messages at the start of the history.
+func (ut *UsageTracker) AddSummary(summary string) {
+	ut.History.mu.Lock()
+	defer ut.History.mu.Unlock()
+	summaryMessage := Message{Role: "system", Content: SummaryPrefix + summary}
+	ut.History.messages = append([]Message{summaryMessage}, ut.History.messages...)
+	ut.saveHistory()
+}
+
+// SetSystemPrompt sets a custom system prompt that is kept across restarts.
+func (ut *UsageTracker) SetSystemPrompt(prompt string) {
+	ut.History.mu.Lock()
+	defer ut.History.mu.Unlock()
+	ut.History.customPrompt = prompt
+	ut.saveHistory()
+}
+
+// ResetSystemPrompt switches back to the system prompt of the persona or the config.
+func (ut *UsageTracker) ResetSystemPrompt() {
+	ut.History.mu.Lock()
+	defer ut.History.mu.Unlock()
+	ut.History.customPrompt = ""
+	ut.saveHistory()
+}
+
+// SetModel sets the model used for the conversation. DefaultModel picks the configured
+// default model, an empty model picks none so the persona's model applies.
+func (ut *UsageTracker) SetModel(model string) {
+	ut.History.mu.Lock()
+	defer ut.History.mu.Unlock()
+	ut.History.model = model
+	ut.saveHistory()
+}
+
+// GetModel returns the model picked for the conversation: conf's default model for
+// DefaultModel, or defaultModel if none was picked.
+func (ut *UsageTracker) GetModel(conf *config.Config, defaultModel string) string {
+	ut.History.mu.Lock()
+	defer ut.History.mu.Unlock()
+	switch ut.History.model {
+	case "":
+		return defaultModel
+	case DefaultModel:
+		return conf.Model.ModelName
+	}
+	return ut.History.model
+}
+
+// SetPersona picks a persona for the conversation, an empty ID picks none. The persona's
+// prompt and model replace a custom system prompt and a picked model.
+func (ut *UsageTracker) SetPersona(id string) {
+	ut.History.mu.Lock()
+	defer ut.History.mu.Unlock()
+	ut.History.persona = id
+	ut.History.model = ""
+	ut.History.customPrompt = ""
+	ut.saveHistory()
+}
+
+// Persona returns the persona picked for the conversation if it is still configured.
+func (ut *UsageTracker) Persona(conf *config.Config) (config.Persona, bool) {
+	ut.History.mu.Lock()
+	id := ut.History.persona
+	ut.History.mu.Unlock()
+	if id == "" {
+		return config.Persona{}, false
+	}
+	return conf.Persona(id)
+}
+
+// ModelFor returns the model for the conversation: the model picked with /model, else
+// the model of the persona, else the default model. The picked model is only used if
+// role may pick it, as the allowlist may have changed since and in groups the members
+// asking can have other roles than the member who picked it.
+func (ut *UsageTracker) ModelFor(conf *config.Config, role string) string {
+	defaultModel := conf.Model.ModelName
+	if persona, ok := ut.Persona(conf); ok && persona.Model != "" {
+		defaultModel = persona.Model
+	}
+	if model := ut.GetModel(conf, defaultModel); model == defaultModel || conf.ModelAllowed(role, model) {
+		return model
+	}
+	return defaultModel
+}
+
+// PromptFor returns the system prompt for the conversation: the prompt set with
+// /reset <prompt>, else the prompt of the persona, else the prompt of the config in effect.
+func (ut *UsageTracker) PromptFor(conf *config.Config) string {
+	ut.History.mu.Lock()
+	custom := ut.History.customPrompt
+	ut.History.mu.Unlock()
+	if custom != "" {
+		return custom
+	}
+	if persona, ok := ut.Persona(conf); ok {
+		return persona.Prompt
+	}
+	return conf.SystemPrompt
+}
+
+// TemperatureFor returns the sampling temperature of the persona or the configured one.
+func (ut *UsageTracker) TemperatureFor(conf *config.Config) float64 {
+	if persona, ok := ut.Persona(conf); ok && persona.Temperature != nil {
+		return *persona.Temperature
+	}
+	return conf.Model.Temperature
+}
+
+// restoreHistory loads the persisted conversation from the history store.
+func (ut *UsageTracker) restoreHistory() {
+	record, err := ut.store.Load(ut.UserID)
+	if err != nil {
+		slog.Error("Failed to restore history", "user_id", ut.UserID, "error", err)
+		return
+	}
+
+	ut.History.mu.Lock()
+	defer ut.History.mu.Unlock()
+	if record.Messages != nil {
+		ut.History.messages = record.Messages
+	}
+	ut.History.customPrompt = record.SystemPrompt
+	ut.History.model = record.Model
+	ut.History.persona = record.Persona
+	ut.History.branches = record.Branches
+	ut.LastMessageTime = record.LastMessageTime
+}
+
+// saveHistory writes the conversation to the history store. History.mu must be held.
+func (ut *UsageTracker) saveHistory() {
+	record := HistoryRecord{
+		Messages:        ut.History.messages,
+		SystemPrompt:    ut.History.customPrompt,
+		Model:           ut.History.model,
+		Persona:         ut.History.persona,
+		LastMessageTime: ut.LastMessageTime,
+		Branches:        ut.History.branches,
+	}
+	if err := ut.store.Save(ut.UserID, record); err != nil {
+		slog.Error("Failed to save history", "user_id", ut.UserID, "error", err)
+	}
+}
+
+// AttachDocument adds a document to the next user turn.
+func (ut *UsageTracker) AttachDocument(doc document.Document) {
+	ut.History.mu.Lock()
+	defer ut.History.mu.Unlock()
+	ut.History.documents = append(ut.History.documents, doc)
+}
+
+// PendingDocuments returns the documents waiting for the next user turn.
+func (ut *UsageTracker) PendingDocuments() []document.Document {
+	ut.History.mu.Lock()
+	defer ut.History.mu.Unlock()
+	return append([]document.Document(nil), ut.History.documents...)
+}
+
+// TakeDocuments returns the pending documents and detaches them.
+func (ut *UsageTracker) TakeDocuments() []document.Document {
+	ut.History.mu.Lock()
+	defer ut.History.mu.Unlock()
+	docs := ut.History.documents
+	ut.History.documents = nil
+	return docs
+}
+
+// AddTurn adds a question and its answer together with the Telegram messages showing them.
+func (ut *UsageTracker) AddTurn(question string, questionID int, answer string, answerIDs []int) {
+	ut.History.mu.Lock()
+	defer ut.History.mu.Unlock()
+	var questionIDs []int
+	if questionID != 0 {
+		questionIDs = []int{questionID}
+	}
+	ut.History.messages = append(ut.History.messages,
+		Message{Role: "user", Content: question, MessageIDs: questionIDs},
+		Message{Role: "assistant", Content: answer, MessageIDs: append([]int(nil), answerIDs...)},
+	)
+	ut.saveHistory()
+}
+
+// IsLatestAnswer reports whether messageID shows the end of the latest answer.
+func (ut *UsageTracker) IsLatestAnswer(messageID int) bool {
+	ut.History.mu.Lock()
+	defer ut.History.mu.Unlock()
+	messages := ut.History.messages
+	if len(messages) == 0 || messages[len(messages)-1].Role != "assistant" {
+		return false
+	}
+	ids := messages[len(messages)-1].MessageIDs
+	return len(ids) > 0 && ids[len(ids)-1] == messageID
+}
+
+// TakeLastTurn removes the latest question and its answer from the history and
+// returns the question, so it can be answered again.
+func (ut *UsageTracker) TakeLastTurn() (Message, bool) {
+	ut.History.mu.Lock()
+	defer ut.History.mu.Unlock()
+	messages := ut.History.messages
+	n := len(messages)
+	if n < 2 || messages[n-1].Role != "assistant" || messages[n-2].Role != "user" {
+		return Message{}, false
+	}
+	question := messages[n-2]
+	ut.History.messages = append([]Message(nil), messages[:n-2]...)
+	ut.saveHistory()
+	return question, true
+}
+
+// FindQuestion reports whether the question shown by messageID is in the history and
+// whether it is the question of the latest turn.
+func (ut *UsageTracker) FindQuestion(messageID int) (found, latest bool) {
+	ut.History.mu.Lock()
+	defer ut.History.mu.Unlock()
+	i := ut.questionIndex(messageID)
+	return i >= 0, i >= 0 && i == len(ut.History.messages)-2
+}
+
+// ReplaceTurn removes the question shown by messageID, its answer and all later turns
+// from the history, so the question can be answered again, and returns the messages
+// of the removed answer. With latestOnly only the question of the latest turn is removed.
+func (ut *UsageTracker) ReplaceTurn(messageID int, latestOnly bool) ([]int, bool) {
+	ut.History.mu.Lock()
+	defer ut.History.mu.Unlock()
+	messages := ut.History.messages
+	i := ut.questionIndex(messageID)
+	if i < 0 || latestOnly && i != len(messages)-2 {
+		return nil, false
+	}
+	var answerIDs []int
+	if i+1 < len(messages) && messages[i+1].Role == "assistant" {
+		answerIDs = messages[i+1].MessageIDs
+	}
+	if i+2 < len(messages) {
+		// The dropped turns stay reachable by replying to their answers
+		ut.archiveBranch(messages)
+	}
+	ut.History.messages = append([]Message(nil), messages[:i]...)
+	ut.saveHistory()
+	return answerIDs, true
+}
+
+// Snapshot is the state of a conversation's turns, see Snapshot and Restore.
+type Snapshot struct {
+	messages []Message
+	branches [][]Message
+}
+
+// Snapshot returns the turns and branches of the conversation, so a change made to
+// answer a question again can be undone with Restore if no answer is added.
+func (ut *UsageTracker) Snapshot() Snapshot {
+	ut.History.mu.Lock()
+	defer ut.History.mu.Unlock()
+	return Snapshot{
+		messages: append([]Message(nil), ut.History.messages...),
+		branches: append([][]Message(nil), ut.History.branches...),
+	}
+}
+
+// Restore puts back the turns and branches of a snapshot.
+func (ut *UsageTracker) Restore(snapshot Snapshot) {
+	ut.History.mu.Lock()
+	defer ut.History.mu.Unlock()
+	ut.History.messages = snapshot.messages
+	ut.History.branches = snapshot.branches
+	ut.saveHistory()
+}
+
+// questionIndex returns the index of the question shown by messageID, or -1.
+// History.mu must be held.
+func (ut *UsageTracker) questionIndex(messageID int) int {
+	for i, msg := range ut.History.messages {
+		if msg.Role == "user" && len(msg.MessageIDs) > 0 && msg.MessageIDs[0] == messageID {
+			return i
+		}
 	}
+	return -1
 }
@@ -0,0 +1,113 @@
+package user
+
+import (
+	"openrouter-gpt-telegram-bot/config"
+	"reflect"
+	"testing"
+)
+
+func newTestTracker(messages ...Message) *UsageTracker {
+	ut := &UsageTracker{UserID: "1", store: NewMemoryStore()}
+	ut.History.messages = messages
+	return ut
+}
+
+func TestTrimHistory(t *testing.T) {
+	history := []Message{
+		{Role: "user", Content: "q1"},
+		{Role: "assistant", Content: "a1"},
+		{Role: "assistant", Content: "a1 continued"},
+		{Role: "user", Content: "q2"},
+		{Role: "assistant", Content: "a2"},
+	}
+	tests := []struct {
+		name        string
+		maxMessages int
+		dropped     int
+	}{
+		{"fits", 5, 0},
+		{"drops the whole first turn", 4, 3},
+		{"never starts with an answer", 2, 3},
+		{"drops everything", 0, 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ut := newTestTracker(append([]Message(nil), history...)...)
+			removed := ut.TrimHistory(func(messages []Message) bool {
+				return len(messages) <= tt.maxMessages
+			})
+
+			if len(removed) != tt.dropped {
+				t.Fatalf("dropped %d messages, want %d", len(removed), tt.dropped)
+			}
+			if tt.dropped > 0 && !reflect.DeepEqual(removed, history[:tt.dropped]) {
+				t.Errorf("dropped %v, want %v", removed, history[:tt.dropped])
+			}
+			if kept := ut.GetMessages(); len(kept) != len(history)-tt.dropped ||
+				len(kept) > 0 && !reflect.DeepEqual(kept, history[tt.dropped:]) {
+				t.Errorf("kept %v, want %v", kept, history[tt.dropped:])
+			}
+		})
+	}
+}
+
+func TestModelFor(t *testing.T) {
+	conf := &config.Config{
+		Model:         config.ModelParameters{ModelName: "base"},
+		Personas:      []config.Persona{{ID: "coder", Model: "coder-model"}, {ID: "plain"}},
+		AllowedModels: map[string][]string{"USER": {"other"}},
+	}
+	tests := []struct {
+		name    string
+		persona string
+		picked  string
+		role    string
+		want    string
+	}{
+		{"default", "", "", "USER", "base"},
+		{"picked model", "", "other", "USER", "other"},
+		{"picked model not allowed", "", "other", "GUEST", "base"},
+		{"persona model", "coder", "", "GUEST", "coder-model"},
+		{"persona without model", "plain", "", "USER", "base"},
+		{"picked model over persona", "coder", "other", "USER", "other"},
+		{"picked model not allowed with persona", "coder", "other", "GUEST", "coder-model"},
+		{"picked default over persona", "coder", DefaultModel, "GUEST", "base"},
+		{"removed persona", "gone", "", "USER", "base"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ut := newTestTracker()
+			ut.SetPersona(tt.persona)
+			ut.SetModel(tt.picked)
+			if got := ut.ModelFor(conf, tt.role); got != tt.want {
+				t.Errorf("ModelFor() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	ut := newTestTracker(
+		Message{Role: "user", Content: "q1", MessageIDs: []int{1}},
+		Message{Role: "assistant", Content: "a1", MessageIDs: []int{2}},
+		Message{Role: "user", Content: "q2", MessageIDs: []int{3}},
+		Message{Role: "assistant", Content: "a2", MessageIDs: []int{4}},
+	)
+	before := ut.GetMessages()
+
+	snapshot := ut.Snapshot()
+	if _, ok := ut.ReplaceTurn(1, false); !ok {
+		t.Fatal("ReplaceTurn failed")
+	}
+	if len(ut.GetMessages()) != 0 || ut.BranchCount() != 1 {
+		t.Fatalf("ReplaceTurn left %d messages and %d branches", len(ut.GetMessages()), ut.BranchCount())
+	}
+
+	ut.Restore(snapshot)
+	if got := ut.GetMessages(); !reflect.DeepEqual(got, before) {
+		t.Errorf("restored %v, want %v", got, before)
+	}
+	if n := ut.BranchCount(); n != 0 {
+		t.Errorf("restored %d branches, want 0", n)
+	}
+}
@@ -0,0 +1,135 @@
+package user
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+)
+
+// RosterEntry holds the access settings of a user changed at runtime by admins.
+type RosterEntry struct {
+	Name string `json:"name,omitempty"`
+	// Role overrides the role from the static config lists, empty keeps it.
+	Role string `json:"role,omitempty"`
+	// Budget overrides the budget of the role, nil keeps it.
+	Budget *float64 `json:"budget,omitempty"`
+}
+
+// Roster is the persisted list of runtime access settings. It is consulted before
+// AdminChatIDs and AllowedUserChatIDs from the config.
+type Roster struct {
+	path    string
+	entries map[string]RosterEntry
+	mu      sync.RWMutex
+}
+
+// LoadRoster reads the roster from path, a missing file gives an empty roster.
+func LoadRoster(path string) (*Roster, error) {
+	r := &Roster{
+		path:    path,
+		entries: make(map[string]RosterEntry),
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading roster: %w", err)
+	}
+	if err := json.Unmarshal(data, &r.entries); err != nil {
+		return nil, fmt.Errorf("error unmarshalling roster: %w", err)
+	}
+	return r, nil
+}
+
+// Get returns the roster entry of a user.
+func (r *Roster) Get(userID string) (RosterEntry, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	entry, ok := r.entries[userID]
+	return entry, ok
+}
+
+// SetRole sets the role of a user, name is recorded for /users if not empty.
+func (r *Roster) SetRole(userID, name, role string) error {
+	return r.update(userID, name, func(entry *RosterEntry) {
+		entry.Role = role
+	})
+}
+
+// SetBudget sets the budget of a user.
+func (r *Roster) SetBudget(userID, name string, budget float64) error {
+	return r.update(userID, name, func(entry *RosterEntry) {
+		entry.Budget = &budget
+	})
+}
+
+// Remove deletes the entry of a user, so the static config lists apply again. It reports
+// whether the user had an entry.
+func (r *Roster) Remove(userID string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	entry, ok := r.entries[userID]
+	if !ok {
+		return false, nil
+	}
+	delete(r.entries, userID)
+	if err := r.save(); err != nil {
+		r.entries[userID] = entry
+		return false, err
+	}
+	return true, nil
+}
+
+// IDs returns the IDs of all users in the roster in sorted order.
+func (r *Roster) IDs() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	ids := make([]string, 0, len(r.entries))
+	for id := range r.entries {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// update changes the entry of a user. If the roster cannot be saved, the change is
+// undone so the roster in use matches the file.
+func (r *Roster) update(userID, name string, change func(entry *RosterEntry)) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	old, existed := r.entries[userID]
+	entry := old
+	if name != "" {
+		entry.Name = name
+	}
+	change(&entry)
+	r.entries[userID] = entry
+	if err := r.save(); err != nil {
+		if existed {
+			r.entries[userID] = old
+		} else {
+			delete(r.entries, userID)
+		}
+		return err
+	}
+	return nil
+}
+
+// save writes the roster to disk. mu must be held.
+func (r *Roster) save() error {
+	data, err := json.MarshalIndent(r.entries, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshalling roster: %w", err)
+	}
+	tmp := r.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("error writing roster: %w", err)
+	}
+	if err := os.Rename(tmp, r.path); err != nil {
+		return fmt.Errorf("error replacing roster: %w", err)
+	}
+	return nil
+}
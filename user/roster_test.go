@@ -0,0 +1,67 @@
+package user
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestRosterRemove(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "roster.json")
+	r, err := LoadRoster(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.SetRole("1", "alice", "ADMIN"); err != nil {
+		t.Fatal(err)
+	}
+
+	if removed, err := r.Remove("1"); err != nil || !removed {
+		t.Fatalf("Remove() = %v, %v, want true, nil", removed, err)
+	}
+	if removed, err := r.Remove("1"); err != nil || removed {
+		t.Fatalf("second Remove() = %v, %v, want false, nil", removed, err)
+	}
+
+	loaded, err := LoadRoster(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := loaded.Get("1"); ok {
+		t.Error("removed entry is still saved")
+	}
+}
+
+func TestRosterKeepsEntriesWhenSaveFails(t *testing.T) {
+	r, err := LoadRoster(filepath.Join(t.TempDir(), "roster.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.SetRole("1", "alice", "USER"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Writing into a missing directory fails
+	r.path = filepath.Join(t.TempDir(), "missing", "roster.json")
+	tests := []struct {
+		name   string
+		change func() error
+	}{
+		{"change of an entry", func() error { return r.SetRole("1", "bob", "ADMIN") }},
+		{"new entry", func() error { return r.SetBudget("2", "carol", 5) }},
+		{"removal", func() error { _, err := r.Remove("1"); return err }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.change(); err == nil {
+				t.Fatal("change was saved")
+			}
+			entry, ok := r.Get("1")
+			if !ok || entry.Role != "USER" || entry.Name != "alice" {
+				t.Errorf("entry 1 = %+v, %v, want the saved one", entry, ok)
+			}
+			if _, ok := r.Get("2"); ok {
+				t.Error("entry 2 was added")
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package user
+
+import (
+	"fmt"
+	"openrouter-gpt-telegram-bot/config"
+	"time"
+)
+
+// HistoryRecord is the persisted state of a conversation.
+type HistoryRecord struct {
+	Messages []Message `json:"messages"`
+	// SystemPrompt is the prompt set with /reset <prompt>, empty when the default prompt is used.
+	SystemPrompt string `json:"system_prompt,omitempty"`
+	// Model is the model picked with /model, empty when the default model is used.
+	Model string `json:"model,omitempty"`
+	// Persona is the ID of the persona picked with /persona, empty when none is picked.
+	Persona         string    `json:"persona,omitempty"`
+	LastMessageTime time.Time `json:"last_message_time"`
+	// Branches are the conversation paths left when the conversation was forked.
+	Branches [][]Message `json:"branches,omitempty"`
+}
+
+// HistoryStore persists conversation history so it survives restarts.
+type HistoryStore interface {
+	// Load returns the stored record for key, or an empty record if there is none.
+	Load(key string) (HistoryRecord, error)
+	// Save replaces the stored record for key.
+	Save(key string, record HistoryRecord) error
+	Close() error
+}
+
+// NewHistoryStore returns the history store selected by conf.History.Store.
+func NewHistoryStore(conf *config.Config) (HistoryStore, error) {
+	switch conf.History.Store {
+	case "bolt":
+		return NewBoltStore(conf.History.Path)
+	case "file":
+		return NewFileStore(conf.History.Path)
+	case "memory":
+		return NewMemoryStore(), nil
+	default:
+		return nil, fmt.Errorf("unknown history store: %s", conf.History.Store)
+	}
+}
+
+// MemoryStore keeps history in memory only, it is lost on restart.
+type MemoryStore struct{}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{}
+}
+
+func (s *MemoryStore) Load(key string) (HistoryRecord, error) {
+	return HistoryRecord{}, nil
+}
+
+func (s *MemoryStore) Save(key string, record HistoryRecord) error {
+	return nil
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
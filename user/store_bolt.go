@@ -0,0 +1,69 @@
+package user
+
+import (
+	"encoding/json"
+	"fmt"
+	"go.etcd.io/bbolt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var historyBucket = []byte("history")
+
+// BoltStore keeps history in an embedded bbolt database, one JSON record per key.
+type BoltStore struct {
+	db *bbolt.DB
+}
+
+func NewBoltStore(path string) (*BoltStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("error creating history directory: %w", err)
+	}
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("error opening history database: %w", err)
+	}
+	err = db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(historyBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error creating history bucket: %w", err)
+	}
+	return &BoltStore{db: db}, nil
+}
+
+func (s *BoltStore) Load(key string) (HistoryRecord, error) {
+	var record HistoryRecord
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		data := tx.Bucket(historyBucket).Get([]byte(key))
+		if data == nil {
+			return nil
+		}
+		return json.Unmarshal(data, &record)
+	})
+	if err != nil {
+		return HistoryRecord{}, fmt.Errorf("error loading history: %w", err)
+	}
+	return record, nil
+}
+
+func (s *BoltStore) Save(key string, record HistoryRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("error marshalling history: %w", err)
+	}
+	err = s.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(historyBucket).Put([]byte(key), data)
+	})
+	if err != nil {
+		return fmt.Errorf("error saving history: %w", err)
+	}
+	return nil
+}
+
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,68 @@
+package user
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// FileStore keeps history as one JSON file per key in a directory.
+type FileStore struct {
+	dir string
+	mu  sync.Mutex
+}
+
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("error creating history directory: %w", err)
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+func (s *FileStore) Load(key string) (HistoryRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var record HistoryRecord
+	data, err := os.ReadFile(s.path(key))
+	if os.IsNotExist(err) {
+		return record, nil
+	}
+	if err != nil {
+		return record, fmt.Errorf("error reading history file: %w", err)
+	}
+	if err := json.Unmarshal(data, &record); err != nil {
+		return HistoryRecord{}, fmt.Errorf("error unmarshalling history: %w", err)
+	}
+	return record, nil
+}
+
+// Save writes the record to a temporary file first so a crash never leaves a truncated file.
+func (s *FileStore) Save(key string, record HistoryRecord) error {
+	data, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshalling history: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tmp := s.path(key) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("error writing history file: %w", err)
+	}
+	if err := os.Rename(tmp, s.path(key)); err != nil {
+		return fmt.Errorf("error replacing history file: %w", err)
+	}
+	return nil
+}
+
+func (s *FileStore) Close() error {
+	return nil
+}
+
+func (s *FileStore) path(key string) string {
+	return filepath.Join(s.dir, key+".json")
+}
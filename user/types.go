@@ -1,7 +1,7 @@
 package user
 
 import (
-	"github.com/sashabaranov/go-openai"
+	"openrouter-gpt-telegram-bot/document"
 	"sync"
 	"time"
 )
@@ -10,23 +10,38 @@ type UsageTracker struct {
 	UserID          string
 	UserName        string
 	LogsDir         string
-	SystemPrompt    string
 	LastMessageTime time.Time
-	CurrentStream   *openai.ChatCompletionStream
 	Usage           *UserUsage
 	History         History
-	UsageMu         sync.Mutex `json:"-"` // Мьютекс для синхронизации доступа к Usage
-	FileMu          sync.Mutex `json:"-"` // Мьютекс для синхронизации доступа к файлу
+	store           HistoryStore
+	roster          *Roster
+	// role is the role at the last access check, it labels the spend metric
+	role string
+	// generation stops the answer being generated, nil while none is running
+	generation   *generation
+	generationMu sync.Mutex
+	UsageMu      sync.Mutex `json:"-"` // Мьютекс для синхронизации доступа к Usage
+	FileMu       sync.Mutex `json:"-"` // Мьютекс для синхронизации доступа к файлу
 }
 
 type Message struct {
-	Role    string
-	Content string
+	Role    string `json:"role"`
+	Content string `json:"content"`
+	// MessageIDs are the Telegram messages showing the message: one for a question,
+	// one or more for an answer, none for messages the bot added itself
+	MessageIDs []int `json:"message_ids,omitempty"`
 }
 
 type History struct {
-	messages []Message
-	mu       sync.Mutex
+	messages     []Message
+	customPrompt string
+	model        string
+	persona      string
+	// branches are the paths the conversation was forked from, see ForkAt
+	branches [][]Message
+	// documents are attached to the next user turn, they are not persisted
+	documents []document.Document
+	mu        sync.Mutex
 }
 
 type UserUsage struct {
@@ -44,23 +59,3 @@ type Cost struct {
 type UsageHist struct {
 	ChatCost map[string]float64 `json:"chat_cost"`
 }
-
-type GenerationResponse struct {
-	Data GenerationData `json:"data"`
-}
-
-type GenerationData struct {
-	ID                     string  `json:"id"`
-	Model                  string  `json:"model"`
-	Streamed               bool    `json:"streamed"`
-	GenerationTime         int     `json:"generation_time"`
-	CreatedAt              string  `json:"created_at"`
-	TokensPrompt           int     `json:"tokens_prompt"`
-	TokensCompletion       int     `json:"tokens_completion"`
-	NativeTokensPrompt     int     `json:"native_tokens_prompt"`
-	NativeTokensCompletion int     `json:"native_tokens_completion"`
-	NumMediaPrompt         int     `json:"num_media_prompt"`
-	NumMediaCompletion     int     `json:"num_media_completion"`
-	Origin                 string  `json:"origin"`
-	TotalCost              float64 `json:"total_cost"`
-}
@@ -1,11 +1,15 @@
 package user
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
-	"net/http"
+	"log/slog"
 	"openrouter-gpt-telegram-bot/config"
+	"openrouter-gpt-telegram-bot/logging"
+	"openrouter-gpt-telegram-bot/metrics"
+	"openrouter-gpt-telegram-bot/provider"
 	"os"
 	"path/filepath"
 	"strings"
@@ -13,7 +17,7 @@ import (
 )
 
 // NewUsageTracker creates a new UsageTracker.
-func NewUsageTracker(userID, userName, logsDir string, conf *config.Config) *UsageTracker {
+func NewUsageTracker(userID, userName, logsDir string, store HistoryStore, roster *Roster, conf *config.Config) *UsageTracker {
 	usageTracker := &UsageTracker{
 		UserID:   userID,
 		UserName: userName,
@@ -26,51 +30,55 @@ func NewUsageTracker(userID, userName, logsDir string, conf *config.Config) *Usa
 		History: History{
 			messages: make([]Message, 0),
 		},
-		SystemPrompt: conf.SystemPrompt,
+		store:  store,
+		roster: roster,
 	}
+	usageTracker.restoreHistory()
 
 	err := usageTracker.loadUsage()
 	if err != nil {
-		log.Printf("Error loading usage for user %s: %v", userID, err)
+		slog.Error("Failed to load usage", "user_id", userID, "error", err)
 	}
 
 	return usageTracker
 }
 
 func (ut *UsageTracker) HaveAccess(conf *config.Config) bool {
-	for _, id := range conf.AdminChatIDs {
-		idStr := fmt.Sprintf("%d", id)
-		if ut.UserID == idStr {
-			log.Println("Admin")
-			return true
-		}
+	role := ut.GetUserRole(conf)
+	ut.UsageMu.Lock()
+	ut.role = role
+	ut.UsageMu.Unlock()
+	if role == "ADMIN" {
+		return true
 	}
 
-	for _, id := range conf.AllowedUserChatIDs {
-		idStr := fmt.Sprintf("%d", id)
-		if ut.UserID == idStr {
-			currentCost := ut.GetCurrentCost(conf.BudgetPeriod)
-
-			if float64(conf.UserBudget) > currentCost {
-				log.Println("ID:", idStr, " UserBudget:", conf.UserBudget, " CurrentCost:", currentCost)
-				return true
-			}
-			return false
-		}
-	}
+	budget := ut.GetBudget(conf)
 	currentCost := ut.GetCurrentCost(conf.BudgetPeriod)
-
-	if float64(conf.GuestBudget) > currentCost {
-		log.Println("ID:", ut.UserID, " GuestBudget:", conf.GuestBudget, " CurrentCost:", currentCost)
+	if budget > currentCost {
 		return true
 	}
-	log.Printf("UserID: %s, AdminChatIDs: %v, AllowedUserChatIDs: %v", ut.UserID, conf.AdminChatIDs, conf.AllowedUserChatIDs)
-	log.Printf("UserBudget: %f, GuestBudget: %f, CurrentCost: %f", float64(conf.UserBudget), float64(conf.GuestBudget), currentCost)
+	slog.Info("Budget exhausted", "user_id", ut.UserID, "role", role, "budget", budget, "cost", currentCost)
 	return false
+}
 
+// GetBudget returns the budget of the user for the budget period: the one set with
+// /setbudget if any, otherwise the budget of the user's role.
+func (ut *UsageTracker) GetBudget(conf *config.Config) float64 {
+	if entry, ok := ut.rosterEntry(); ok && entry.Budget != nil {
+		return *entry.Budget
+	}
+	if ut.GetUserRole(conf) == "USER" {
+		return conf.UserBudget
+	}
+	return conf.GuestBudget
 }
 
+// GetUserRole returns the role set at runtime with /grant or /revoke if any,
+// otherwise the role from the static config lists.
 func (ut *UsageTracker) GetUserRole(conf *config.Config) string {
+	if entry, ok := ut.rosterEntry(); ok && entry.Role != "" {
+		return entry.Role
+	}
 	for _, id := range conf.AdminChatIDs {
 		idStr := fmt.Sprintf("%d", id)
 		if ut.UserID == idStr {
@@ -86,6 +94,13 @@ func (ut *UsageTracker) GetUserRole(conf *config.Config) string {
 	return "GUEST"
 }
 
+func (ut *UsageTracker) rosterEntry() (RosterEntry, bool) {
+	if ut.roster == nil {
+		return RosterEntry{}, false
+	}
+	return ut.roster.Get(ut.UserID)
+}
+
 func (ut *UsageTracker) CanViewStats(conf *config.Config) bool {
 	userRole := ut.GetUserRole(conf)
 	return userRole == "ADMIN" || (conf.StatsMinRole == "USER" && userRole != "GUEST")
@@ -110,14 +125,14 @@ func (ut *UsageTracker) loadOrCreateUsage() error {
 	} else {
 		data, err := os.ReadFile(userFile)
 		if err != nil {
-			log.Println(err)
+			slog.Error("Failed to read usage", "user_id", ut.UserID, "error", err)
 			return err
 		}
 		ut.UsageMu.Lock() // Added lock
 		err = json.Unmarshal(data, ut.Usage)
 		ut.UsageMu.Unlock() // Added unlock
 		if err != nil {
-			log.Println(err)
+			slog.Error("Failed to parse usage", "user_id", ut.UserID, "error", err)
 			return err
 		}
 	}
@@ -134,14 +149,18 @@ func (ut *UsageTracker) saveUsage() error {
 	ut.UsageMu.Unlock()
 
 	if err != nil {
-		log.Printf("Error marshalling usage data for user %s: %v", ut.UserID, err)
+		slog.Error("Failed to encode usage", "user_id", ut.UserID, "error", err)
 		return fmt.Errorf("error marshalling usage data: %w", err)
 	}
 
+	// Write to a temporary file first so an interrupted write cannot leave a truncated file
 	filename := fmt.Sprintf("%s/%s.json", ut.LogsDir, ut.UserID)
-	err = os.WriteFile(filename, data, 0644) // Use os.WriteFile instead of ioutil.WriteFile
+	err = os.WriteFile(filename+".tmp", data, 0644)
+	if err == nil {
+		err = os.Rename(filename+".tmp", filename)
+	}
 	if err != nil {
-		log.Printf("Error writing usage data to file for user %s: %v", ut.UserID, err)
+		slog.Error("Failed to write usage", "user_id", ut.UserID, "error", err)
 		return fmt.Errorf("error writing usage data to file: %w", err)
 	}
 
@@ -154,7 +173,8 @@ func (ut *UsageTracker) loadUsage() error {
 	data, err := os.ReadFile(filePath)
 	if err != nil {
 		if os.IsNotExist(err) {
-			log.Printf("File not found for user %s, creating new usage data.", ut.UserID)
+			// The file is written on the first AddCost, so conversations that are
+			// never billed (forum topics) do not leave empty usage files behind
 			ut.UsageMu.Lock()
 			ut.Usage = &UserUsage{ // Initialize as pointer
 				UsageHistory: UsageHist{
@@ -162,9 +182,9 @@ func (ut *UsageTracker) loadUsage() error {
 				},
 			}
 			ut.UsageMu.Unlock()
-			return ut.saveUsage()
+			return nil
 		}
-		log.Printf("Error reading usage data from file for user %s: %v", ut.UserID, err)
+		slog.Error("Failed to read usage", "user_id", ut.UserID, "error", err)
 		return fmt.Errorf("error reading usage data from file: %w", err)
 	}
 
@@ -173,7 +193,7 @@ func (ut *UsageTracker) loadUsage() error {
 	err = json.Unmarshal(data, &usage) // Unmarshal into temporary variable
 	if err != nil {
 		ut.UsageMu.Unlock()
-		log.Printf("Error unmarshalling usage data for user %s: %v", ut.UserID, err)
+		slog.Error("Failed to parse usage", "user_id", ut.UserID, "error", err)
 		return fmt.Errorf("error unmarshalling usage data: %w", err)
 	}
 	ut.Usage = &usage // Assign pointer to unmarshaled data
@@ -191,12 +211,36 @@ func (ut *UsageTracker) AddCost(cost float64) {
 		ut.Usage.UsageHistory.ChatCost = make(map[string]float64)
 	}
 	ut.Usage.UsageHistory.ChatCost[today] += cost
+	role := ut.role
+	if role == "" {
+		role = "GUEST"
+	}
+	metrics.Spend.WithLabelValues(role).Add(cost)
 
 	ut.UsageMu.Unlock() // Переместил Unlock после вызова saveUsage()
 
 	if err := ut.saveUsage(); err != nil {
-		log.Printf("Failed to save usage after adding cost for user %s: %v", ut.UserID, err)
+		slog.Error("Failed to save usage after adding cost", "user_id", ut.UserID, "error", err)
+	}
+}
+
+// Flush writes the usage to disk if anything was recorded.
+func (ut *UsageTracker) Flush() error {
+	ut.UsageMu.Lock()
+	recorded := ut.Usage != nil && len(ut.Usage.UsageHistory.ChatCost) > 0
+	ut.UsageMu.Unlock()
+	if !recorded {
+		return nil
 	}
+	return ut.saveUsage()
+}
+
+// ResetUsage clears the usage history and saves it.
+func (ut *UsageTracker) ResetUsage() error {
+	ut.UsageMu.Lock()
+	ut.Usage.UsageHistory.ChatCost = make(map[string]float64)
+	ut.UsageMu.Unlock()
+	return ut.saveUsage()
 }
 
 // GetCurrentCost returns the current cost based on the specified period.
@@ -214,13 +258,13 @@ func (ut *UsageTracker) GetCurrentCost(period string) float64 {
 	case "monthly":
 		cost, err = calculateCostForMonth(ut.Usage.UsageHistory.ChatCost, today)
 		if err != nil {
-			log.Printf("Error calculating monthly cost for user %s: %v", ut.UserID, err)
+			slog.Error("Failed to calculate monthly cost", "user_id", ut.UserID, "error", err)
 			return 0.0 // Или другое значение по умолчанию
 		}
 	case "total":
 		cost = calculateTotalCost(ut.Usage.UsageHistory.ChatCost)
 	default:
-		log.Printf("Invalid period: %s. Valid periods are 'daily', 'monthly', 'total'.", period)
+		slog.Warn("Invalid budget period, expected daily, monthly or total", "period", period)
 		return 0.0
 	}
 
@@ -258,35 +302,20 @@ func calculateTotalCost(chatCost map[string]float64) float64 {
 	return totalCost
 }
 
-// GetUsageFromApi Get cost of current generation
-func (ut *UsageTracker) GetUsageFromApi(id string, conf *config.Config) error {
-	url := fmt.Sprintf("https://openrouter.ai/api/v1/generation?id=%s", id)
-
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		log.Printf("Error creating request for user %s: %v", ut.UserID, err)
-		return fmt.Errorf("error creating request: %w", err)
-	}
-
-	bearer := fmt.Sprintf("Bearer %s", conf.OpenAIApiKey)
-	req.Header.Add("Authorization", bearer)
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		log.Printf("Error sending request for user %s: %v", ut.UserID, err)
-		return fmt.Errorf("error sending request: %w", err)
+// AddGenerationCost gets the cost of a finished generation from the provider and adds it to the usage.
+// The lookup is not canceled with ctx, stopped answers are charged too.
+func (ut *UsageTracker) AddGenerationCost(ctx context.Context, p provider.Provider, id string) error {
+	logger := logging.From(ctx).With("response_id", id)
+	cost, err := p.GenerationCost(context.WithoutCancel(ctx), id)
+	if errors.Is(err, provider.ErrNotSupported) {
+		return nil
 	}
-	defer resp.Body.Close()
-
-	var generationResponse GenerationResponse
-	err = json.NewDecoder(resp.Body).Decode(&generationResponse)
 	if err != nil {
-		log.Printf("Error decoding response for user %s: %v", ut.UserID, err)
-		return fmt.Errorf("error decoding response: %w", err)
+		logger.Error("Failed to get generation cost", "error", err)
+		return fmt.Errorf("error getting generation cost: %w", err)
 	}
 
-	fmt.Printf("Total Cost for user %s: %.6f\n", ut.UserID, generationResponse.Data.TotalCost)
-	ut.AddCost(generationResponse.Data.TotalCost)
+	logger.Info("Charged generation", "payer_id", ut.UserID, "cost", cost)
+	ut.AddCost(cost)
 	return nil
 }
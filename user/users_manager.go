@@ -2,6 +2,8 @@
 package user
 
 import (
+	"errors"
+	"fmt"
 	"openrouter-gpt-telegram-bot/config"
 	"strconv"
 	"sync"
@@ -9,26 +11,70 @@ import (
 
 type Manager struct {
 	LogsDir string
-	users   map[int64]*UsageTracker
+	store   HistoryStore
+	Roster  *Roster
+	users   map[string]*UsageTracker
 	mu      sync.Mutex
 }
 
-func NewUserManager(logsDir string) *Manager {
+func NewUserManager(logsDir string, store HistoryStore, roster *Roster) *Manager {
 	return &Manager{
 		LogsDir: logsDir,
-		users:   make(map[int64]*UsageTracker),
+		store:   store,
+		Roster:  roster,
+		users:   make(map[string]*UsageTracker),
 	}
 }
 
+// GetUser returns the tracker of a user or of a group chat, which is billed like a user.
 func (um *Manager) GetUser(userID int64, userName string, conf *config.Config) *UsageTracker {
+	return um.get(strconv.FormatInt(userID, 10), userName, conf)
+}
+
+// Lookup returns the tracker of a user or group chat if it was created before.
+func (um *Manager) Lookup(userID int64) (*UsageTracker, bool) {
 	um.mu.Lock()
 	defer um.mu.Unlock()
+	user, ok := um.users[strconv.FormatInt(userID, 10)]
+	return user, ok
+}
+
+// GetConversation returns the tracker holding the shared history of a chat.
+// Each forum topic gets its own history, threadID is zero outside forum topics.
+func (um *Manager) GetConversation(chatID int64, threadID int, title string, conf *config.Config) *UsageTracker {
+	if threadID == 0 {
+		return um.GetUser(chatID, title, conf)
+	}
+	return um.get(fmt.Sprintf("%d_%d", chatID, threadID), title, conf)
+}
 
-	if user, exists := um.users[userID]; exists {
+func (um *Manager) get(id, name string, conf *config.Config) *UsageTracker {
+	um.mu.Lock()
+	defer um.mu.Unlock()
+
+	if user, exists := um.users[id]; exists {
+		if user.UserName == "" {
+			// Trackers looked up by admin commands are created without a name
+			user.UserName = name
+		}
 		return user
 	}
 
-	user := NewUsageTracker(strconv.FormatInt(userID, 10), userName, um.LogsDir, conf)
-	um.users[userID] = user
+	user := NewUsageTracker(id, name, um.LogsDir, um.store, um.Roster, conf)
+	um.users[id] = user
 	return user
 }
+
+// Flush writes the usage of all known users and chats to disk.
+func (um *Manager) Flush() error {
+	um.mu.Lock()
+	defer um.mu.Unlock()
+
+	var errs []error
+	for _, user := range um.users {
+		if err := user.Flush(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}